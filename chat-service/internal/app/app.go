@@ -1,11 +1,20 @@
 package app
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
+	"chat-service/internal/bot"
+	"chat-service/internal/broker"
+	"chat-service/internal/config"
 	"chat-service/internal/grpc"
 	"chat-service/internal/handler"
+	"chat-service/internal/hub"
+	"chat-service/internal/lifecycle"
+	"chat-service/internal/presence"
+	"chat-service/internal/ratelimit"
 	"chat-service/internal/repository"
 	"chat-service/internal/service"
 	"chat-service/pkg/models"
@@ -13,10 +22,25 @@ import (
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
+// wsSendBuffer - сколько сообщений может ждать отправки одному WebSocket-клиенту,
+// прежде чем он будет отключён как медленный
+const wsSendBuffer = 64
+
+// presenceTTL - соединение без heartbeat дольше этого считается закрытым (реплика упала);
+// раз в presenceSweepInterval ушедшие так пользователи отмечаются вне сети
+const (
+	presenceTTL           = time.Minute
+	presenceSweepInterval = 15 * time.Second
+)
+
+// archiveInterval - как часто искать чаты для архивации (срок задаёт CHAT_ARCHIVE_AFTER)
+const archiveInterval = time.Hour
+
 // App - структура приложения
 type App struct {
 	FiberApp *fiber.App
@@ -40,27 +64,114 @@ func NewApp() *App {
 		log.Fatalf("❌ Ошибка подключения к MySQL: %v", err)
 	}
 
-	if err := db.AutoMigrate(&models.Chat{}, &models.Message{}); err != nil {
+	if err := db.AutoMigrate(&models.Chat{}, &models.ChatMember{}, &models.Message{}, &models.MessageEdit{}, &models.UserSettings{}); err != nil {
 		log.Fatalf("❌ Ошибка миграции базы данных: %v", err)
 	}
+	if err := repository.MigrateChatMembers(db); err != nil {
+		log.Fatalf("❌ Ошибка миграции участников чатов: %v", err)
+	}
+	if err := repository.BackfillChatPublicIDs(db); err != nil {
+		log.Fatalf("❌ Ошибка миграции публичных ID чатов: %v", err)
+	}
+	if err := repository.DropLegacyMessageIndex(db); err != nil {
+		log.Fatalf("❌ Ошибка миграции индексов сообщений: %v", err)
+	}
+	if err := repository.BackfillChatStates(db); err != nil {
+		log.Fatalf("❌ Ошибка миграции состояний чатов: %v", err)
+	}
 	log.Println("✅ Таблицы созданы или уже существуют")
 
 	// 🔹 Создаем репозитории
 	chatRepo := repository.NewChatRepository(db)
 
+	// 🔹 Боты-собеседники для чатов без живого собеседника
+	bots := bot.NewDefaultRegistry()
+
+	// 🔹 Подключаем Redis: через него события чатов доходят до клиентов на всех репликах
+	redisHost := os.Getenv("REDIS_HOST")
+	if redisHost == "" {
+		log.Fatal("❌ Не задан REDIS_HOST")
+	}
+	redisPort := os.Getenv("REDIS_PORT")
+	if redisPort == "" {
+		redisPort = "6379"
+	}
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: redisHost + ":" + redisPort,
+	})
+	chatHub := hub.NewHub(wsSendBuffer)
+	chatBroker := broker.NewBroker(redisClient, chatHub)
+	go func() {
+		if err := chatBroker.Run(context.Background()); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}()
+
+	// 🔹 О завершённых чатах узнаёт matchmaking-service (через тот же Redis)
+	chatLifecycle := lifecycle.NewPublisher(redisClient)
+
+	// 🔹 Присутствие пользователей в сети тоже хранится в Redis, общем для реплик
+	tracker := presence.NewTracker(redisClient, presenceTTL)
+
+	// 🔹 Ограничения частоты сообщений: общие для реплик, меняются через админское API
+	rateCfg, err := config.LoadRateLimitConfig()
+	if err != nil {
+		log.Fatalf("❌ Ошибка настроек ограничения сообщений: %v", err)
+	}
+	limiter := ratelimit.NewLimiter(redisClient, ratelimit.Limits{
+		UserRate:               rateCfg.UserRate,
+		UserBurst:              rateCfg.UserBurst,
+		ChatRate:               rateCfg.ChatRate,
+		ChatBurst:              rateCfg.ChatBurst,
+		DuplicateWindowSeconds: int(rateCfg.DuplicateWindow / time.Second),
+		DuplicateMinLength:     rateCfg.DuplicateMinLength,
+	})
+
+	// 🔹 Окно редактирования сообщений и срок до архивации чатов
+	chatCfg, err := config.LoadChatConfig()
+	if err != nil {
+		log.Fatalf("❌ Ошибка настроек чатов: %v", err)
+	}
+
+	// 🔹 Heartbeat, таймауты и лимиты WebSocket-соединений
+	wsCfg, err := config.LoadWebSocketConfig()
+	if err != nil {
+		log.Fatalf("❌ Ошибка настроек WebSocket: %v", err)
+	}
+
+	// 🔹 Создаем WebSocket-обработчик: через него сервис уведомляет участников чатов
+	chatHandler := handler.NewChatHandler(chatRepo, bots, chatHub, chatBroker, tracker, limiter, chatLifecycle, chatCfg.EditWindow, wsCfg)
+
+	// 🔹 Отмечаем вне сети пользователей упавших реплик (асинхронно)
+	go chatHandler.RunPresenceSweeper(context.Background(), presenceSweepInterval)
+
 	// 🔹 Создаем сервис
-	chatService := service.NewChatService(chatRepo)
+	chatService := service.NewChatService(chatRepo, chatHandler, bots, chatLifecycle)
+
+	// 🔹 Архивируем давно завершённые и заброшенные чаты (асинхронно)
+	go chatService.RunArchiver(context.Background(), archiveInterval, chatCfg.ArchiveAfter)
 
 	// 🔹 Запускаем gRPC-сервер (асинхронно)
 	go grpc.RunGRPCServer(chatService)
 
 	// 🔹 Создаем HTTP-сервер с Fiber
-	app := fiber.New()
-	chatHandler := handler.NewChatHandler(chatRepo)
+	// IP клиента для лимита соединений передаёт шлюз
+	app := fiber.New(fiber.Config{ProxyHeader: "X-Real-IP"})
 
-	app.Get("/ws/chat/:chat_id", websocket.New(chatHandler.WebSocketHandler))
+	app.Get("/ws/chat/:chat_id", chatHandler.AuthorizeWebSocket, websocket.New(chatHandler.WebSocketHandler, handler.WebSocketConfig))
 	app.Get("/api/chat/history/:chat_id", chatHandler.GetChatHistory)
 	app.Get("/api/chat/all", chatHandler.GetAllChats)
+	app.Post("/api/chat/read/:chat_id", chatHandler.MarkRead)
+	app.Post("/api/chat/leave/:chat_id", chatHandler.LeaveChat)
+	app.Get("/api/chat/protocol/schema", chatHandler.GetProtocolSchema)
+	app.Get("/api/chat/presence/:chat_id", chatHandler.GetPresence)
+	app.Get("/api/chat/settings", chatHandler.GetSettings)
+	app.Put("/api/chat/settings", chatHandler.UpdateSettings)
+
+	adminHandler := handler.NewAdminHandler(limiter)
+	admin := app.Group("/api/chat/admin", handler.RequireAdminToken(rateCfg.AdminToken))
+	admin.Get("/rate-limits", adminHandler.GetRateLimits)
+	admin.Put("/rate-limits", adminHandler.SetRateLimits)
 
 	return &App{
 		FiberApp: app,
@@ -0,0 +1,50 @@
+// Package bot - собеседники-боты для чатов, в которые не нашлось живого собеседника
+package bot
+
+import (
+	"context"
+	"sort"
+)
+
+// Bot - собеседник-бот: получает сообщения чата и отвечает на них
+type Bot interface {
+	// Name - имя, под которым бот указывается в чате
+	Name() string
+	// Reply - ответ на сообщение пользователя; пустая строка - промолчать
+	Reply(ctx context.Context, chatID int64, text string) (string, error)
+}
+
+// Registry - доступные боты по имени
+type Registry struct {
+	bots map[string]Bot
+}
+
+// NewRegistry - реестр из переданных ботов
+func NewRegistry(bots ...Bot) *Registry {
+	r := &Registry{bots: make(map[string]Bot, len(bots))}
+	for _, b := range bots {
+		r.bots[b.Name()] = b
+	}
+	return r
+}
+
+// NewDefaultRegistry - реестр со встроенными ботами
+func NewDefaultRegistry() *Registry {
+	return NewRegistry(NewEchoBot(), NewRuleBot(RuleBotName, DefaultRules, DefaultFallbacks))
+}
+
+// Get - бот по имени
+func (r *Registry) Get(name string) (Bot, bool) {
+	b, ok := r.bots[name]
+	return b, ok
+}
+
+// Names - имена всех ботов по алфавиту
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.bots))
+	for name := range r.bots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
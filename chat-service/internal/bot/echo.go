@@ -0,0 +1,22 @@
+package bot
+
+import "context"
+
+// EchoBotName - имя эхо-бота
+const EchoBotName = "echo"
+
+// EchoBot - повторяет сообщения пользователя; удобен для проверки клиента
+type EchoBot struct{}
+
+// NewEchoBot - конструктор эхо-бота
+func NewEchoBot() *EchoBot {
+	return &EchoBot{}
+}
+
+func (b *EchoBot) Name() string {
+	return EchoBotName
+}
+
+func (b *EchoBot) Reply(_ context.Context, _ int64, text string) (string, error) {
+	return text, nil
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// RuleBotName - имя встроенного бота на правилах
+const RuleBotName = "rules"
+
+// Rule - ответ на сообщения, содержащие одно из ключевых слов (или фраз) целиком
+type Rule struct {
+	Keywords []string
+	Answer   string
+}
+
+// DefaultRules - правила встроенного бота
+var DefaultRules = []Rule{
+	{Keywords: []string{"привет", "здравствуй", "хай", "hello", "hi"}, Answer: "Привет! Живых собеседников пока нет, так что поболтаю с тобой я 🙂"},
+	{Keywords: []string{"бот", "робот", "bot"}, Answer: "Да, я бот. Как только появится живой собеседник, можно будет начать новый поиск."},
+	{Keywords: []string{"как дела", "как ты"}, Answer: "У меня всё стабильно, я же бот. А у тебя как?"},
+	{Keywords: []string{"пока", "до свидания", "bye"}, Answer: "Пока! Заглядывай ещё."},
+}
+
+// DefaultFallbacks - ответы встроенного бота, когда ни одно правило не подошло
+var DefaultFallbacks = []string{
+	"Интересно, расскажи подробнее.",
+	"Понимаю.",
+	"А что было дальше?",
+	"Хороший вопрос. А ты сам что думаешь?",
+}
+
+// RuleBot - отвечает по первому подходящему правилу, иначе - по очереди одной из заготовленных фраз
+type RuleBot struct {
+	name      string
+	rules     []Rule
+	fallbacks []string
+	next      atomic.Uint64
+}
+
+// NewRuleBot - конструктор бота на правилах
+func NewRuleBot(name string, rules []Rule, fallbacks []string) *RuleBot {
+	return &RuleBot{name: name, rules: rules, fallbacks: fallbacks}
+}
+
+func (b *RuleBot) Name() string {
+	return b.name
+}
+
+func (b *RuleBot) Reply(_ context.Context, _ int64, text string) (string, error) {
+	// Слова через одиночные пробелы, чтобы "бот" не находился в "работе"
+	words := " " + strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ") + " "
+	for _, rule := range b.rules {
+		for _, keyword := range rule.Keywords {
+			if strings.Contains(words, " "+keyword+" ") {
+				return rule.Answer, nil
+			}
+		}
+	}
+	if len(b.fallbacks) == 0 {
+		return "", nil
+	}
+	i := b.next.Add(1) - 1
+	return b.fallbacks[i%uint64(len(b.fallbacks))], nil
+}
@@ -0,0 +1,233 @@
+// Package broker - рассылка событий чатов между репликами chat-service через Redis pub/sub.
+//
+// Каждое событие публикуется в канал своего чата. Реплика подписана только на каналы чатов,
+// к которым у неё подключены клиенты: подписка появляется вместе с первым клиентом комнаты
+// хаба и снимается вместе с последним. Локально события не доставляются в обход Redis,
+// поэтому все реплики видят события чата в одном порядке - в том, в котором их принял Redis.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chat-service/internal/hub"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// channelPrefix - префикс каналов событий: chat:events:<chat_id>
+const channelPrefix = "chat:events:"
+
+// Отметки доставки сообщений: chat:delivered:<message_id>. Хранятся недолго - только
+// чтобы реплики не разослали подтверждение одного сообщения несколько раз.
+const (
+	deliveredPrefix = "chat:delivered:"
+	deliveredTTL    = 10 * time.Minute
+)
+
+// Broker - публикация событий чатов и их доставка локальным клиентам
+type Broker struct {
+	client *redis.Client
+	hub    *hub.Hub
+	pubsub *redis.PubSub
+
+	mu      sync.Mutex
+	rooms   map[int64]chan struct{} // чаты с локальными клиентами; канал закрывается, когда подписка подтверждена
+	sent    map[int64]bool          // на какие каналы отправлена подписка (последняя команда - subscribe)
+	active  map[int64]bool          // какие подписки Redis подтвердил
+	pending map[int64]struct{}      // чаты, подписку на которые нужно привести в соответствие с rooms
+	wake    chan struct{}
+}
+
+// NewBroker - конструктор; события из Redis доставляются клиентам chatHub, а подписки
+// следуют за его комнатами
+func NewBroker(client *redis.Client, chatHub *hub.Hub) *Broker {
+	b := &Broker{
+		client:  client,
+		hub:     chatHub,
+		pubsub:  client.Subscribe(context.Background()),
+		rooms:   make(map[int64]chan struct{}),
+		sent:    make(map[int64]bool),
+		active:  make(map[int64]bool),
+		pending: make(map[int64]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+	chatHub.SetRoomHooks(b.roomOpened, b.roomClosed)
+	return b
+}
+
+func channel(chatID int64) string {
+	return channelPrefix + strconv.FormatInt(chatID, 10)
+}
+
+// Publish - публикует готовый кадр чата для всех реплик. Публикации одного отправителя,
+// сделанные последовательно, доходят до клиентов в том же порядке.
+func (b *Broker) Publish(ctx context.Context, chatID int64, payload []byte) error {
+	if err := b.client.Publish(ctx, channel(chatID), payload).Err(); err != nil {
+		return fmt.Errorf("ошибка публикации события чата %d: %w", chatID, err)
+	}
+	return nil
+}
+
+// MarkDelivered - отмечает, что сообщение дошло до собеседника. Возвращает true только
+// для первой отметки, чтобы подтверждение доставки рассылалось один раз на все реплики.
+func (b *Broker) MarkDelivered(ctx context.Context, messageID int64) (bool, error) {
+	key := deliveredPrefix + strconv.FormatInt(messageID, 10)
+	first, err := b.client.SetNX(ctx, key, 1, deliveredTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("ошибка отметки доставки сообщения %d: %w", messageID, err)
+	}
+	return first, nil
+}
+
+// roomOpened - в чате появился первый локальный клиент: нужна подписка на его канал
+func (b *Broker) roomOpened(chatID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ready := make(chan struct{})
+	b.rooms[chatID] = ready
+	if b.sent[chatID] && b.active[chatID] {
+		close(ready)
+	}
+	b.schedule(chatID)
+}
+
+// roomClosed - последний локальный клиент чата отключился: подписка больше не нужна
+func (b *Broker) roomClosed(chatID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.rooms, chatID)
+	b.schedule(chatID)
+}
+
+// schedule - просит Run сверить подписку на чат; вызывается под b.mu. Хуки хаба
+// вызываются под его мьютексом, поэтому сами в Redis не ходят.
+func (b *Broker) schedule(chatID int64) {
+	b.pending[chatID] = struct{}{}
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// WaitSubscribed - ждёт, пока Redis подтвердит подписку на канал чата с локальными
+// клиентами; после этого ни одно событие чата не пройдёт мимо реплики
+func (b *Broker) WaitSubscribed(ctx context.Context, chatID int64) error {
+	b.mu.Lock()
+	ready, ok := b.rooms[chatID]
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("подписка на события чата %d не подтверждена: %w", chatID, ctx.Err())
+	}
+}
+
+// sync - подписывается на каналы чатов, в которых появились локальные клиенты,
+// и отписывается от опустевших. Команды уходят в Redis в порядке изменений.
+func (b *Broker) sync(ctx context.Context) {
+	b.mu.Lock()
+	var subscribe, unsubscribe []string
+	for chatID := range b.pending {
+		_, want := b.rooms[chatID]
+		if want != b.sent[chatID] {
+			b.sent[chatID] = want
+			if want {
+				subscribe = append(subscribe, channel(chatID))
+			} else {
+				unsubscribe = append(unsubscribe, channel(chatID))
+			}
+		}
+		delete(b.pending, chatID)
+	}
+	b.mu.Unlock()
+
+	// go-redis помнит каналы и после обрыва соединения подписывается на них заново,
+	// поэтому ошибка здесь не теряет подписку
+	if len(unsubscribe) > 0 {
+		if err := b.pubsub.Unsubscribe(ctx, unsubscribe...); err != nil {
+			log.Printf("⚠️ Ошибка отписки от событий чатов: %v", err)
+		}
+	}
+	if len(subscribe) > 0 {
+		if err := b.pubsub.Subscribe(ctx, subscribe...); err != nil {
+			log.Printf("⚠️ Ошибка подписки на события чатов: %v", err)
+		}
+	}
+}
+
+// confirm - учитывает подтверждение подписки или отписки от Redis
+func (b *Broker) confirm(sub *redis.Subscription) {
+	chatID, ok := chatIDOf(sub.Channel)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch sub.Kind {
+	case "subscribe":
+		b.active[chatID] = true
+		if ready, ok := b.rooms[chatID]; ok && b.sent[chatID] {
+			select {
+			case <-ready:
+			default:
+				close(ready)
+			}
+		}
+	case "unsubscribe":
+		delete(b.active, chatID)
+		if !b.sent[chatID] {
+			delete(b.sent, chatID)
+		}
+	}
+}
+
+// Run - подписывается на события чатов с локальными клиентами и передаёт их этим клиентам,
+// пока не отменён ctx. Возвращает ошибку, если Redis недоступен при запуске.
+func (b *Broker) Run(ctx context.Context) error {
+	defer b.pubsub.Close()
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("ошибка подключения к Redis для событий чатов: %w", err)
+	}
+	log.Println("✅ Подписка на события чатов в Redis")
+
+	ch := b.pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.wake:
+			b.sync(ctx)
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				b.confirm(msg)
+			case *redis.Message:
+				chatID, ok := chatIDOf(msg.Channel)
+				if !ok {
+					log.Printf("⚠️ Неизвестный канал событий %q", msg.Channel)
+					continue
+				}
+				b.hub.Broadcast(chatID, []byte(msg.Payload))
+			}
+		}
+	}
+}
+
+// chatIDOf - ID чата по имени его канала событий
+func chatIDOf(ch string) (int64, bool) {
+	chatID, err := strconv.ParseInt(strings.TrimPrefix(ch, channelPrefix), 10, 64)
+	return chatID, err == nil
+}
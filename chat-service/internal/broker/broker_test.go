@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-service/internal/hub"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// replica - хаб и брокер одной реплики chat-service
+type replica struct {
+	hub    *hub.Hub
+	broker *Broker
+}
+
+// newReplicas - n реплик на одном Redis; брокеры работают до конца теста
+func newReplicas(t *testing.T, n int) (*redis.Client, []replica) {
+	t.Helper()
+	addr := miniredis.RunT(t).Addr()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	replicas := make([]replica, n)
+	for i := range replicas {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		t.Cleanup(func() { client.Close() })
+		chatHub := hub.NewHub(64)
+		b := NewBroker(client, chatHub)
+		go func() {
+			if err := b.Run(ctx); err != nil {
+				t.Errorf("брокер остановился: %v", err)
+			}
+		}()
+		replicas[i] = replica{hub: chatHub, broker: b}
+	}
+	return redis.NewClient(&redis.Options{Addr: addr}), replicas
+}
+
+// join - подключает клиента к чату на реплике и ждёт подписки на события чата
+func join(t *testing.T, r replica, chatID, userID int64) *hub.Client {
+	t.Helper()
+	client := r.hub.Join(chatID, userID)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.broker.WaitSubscribed(ctx, chatID); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// receive - следующий кадр клиента или ошибка теста, если его нет
+func receive(t *testing.T, client *hub.Client) string {
+	t.Helper()
+	select {
+	case payload := <-client.Send():
+		return string(payload)
+	case <-time.After(time.Second):
+		t.Fatalf("клиент пользователя %d не получил кадр", client.UserID)
+		return ""
+	}
+}
+
+// expectNothing - у клиента нет лишних кадров
+func expectNothing(t *testing.T, client *hub.Client) {
+	t.Helper()
+	select {
+	case payload := <-client.Send():
+		t.Fatalf("клиент пользователя %d получил лишний кадр %q", client.UserID, payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// numSub - сколько подписчиков у канала событий чата
+func numSub(t *testing.T, rdb *redis.Client, chatID int64) int64 {
+	t.Helper()
+	counts, err := rdb.PubSubNumSub(context.Background(), channel(chatID)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts[channel(chatID)]
+}
+
+// waitNumSub - ждёт, пока у канала чата станет want подписчиков
+func waitNumSub(t *testing.T, rdb *redis.Client, chatID, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for numSub(t, rdb, chatID) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("у канала чата %d %d подписчиков, ожидалось %d", chatID, numSub(t, rdb, chatID), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCrossReplicaDelivery(t *testing.T) {
+	_, replicas := newReplicas(t, 2)
+	a, b := replicas[0], replicas[1]
+	sender := join(t, a, 1, 1)
+	partner := join(t, b, 1, 2)
+
+	if err := a.broker.Publish(context.Background(), 1, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, partner); got != "hello" {
+		t.Fatalf("собеседник на другой реплике получил %q", got)
+	}
+	// Отправитель получает своё событие один раз - через Redis, а не ещё и локально
+	if got := receive(t, sender); got != "hello" {
+		t.Fatalf("отправитель получил %q", got)
+	}
+	expectNothing(t, sender)
+	expectNothing(t, partner)
+}
+
+func TestSubscriptionFollowsLocalClients(t *testing.T) {
+	rdb, replicas := newReplicas(t, 2)
+	a, b := replicas[0], replicas[1]
+
+	first := join(t, a, 1, 1)
+	second := join(t, a, 1, 2)
+	other := join(t, b, 2, 3)
+	waitNumSub(t, rdb, 1, 1)
+	waitNumSub(t, rdb, 2, 1)
+
+	// Реплика без клиентов чата не получает его события
+	if err := a.broker.Publish(context.Background(), 1, []byte("only a")); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, first)
+	receive(t, second)
+	expectNothing(t, other)
+
+	a.hub.Leave(first)
+	waitNumSub(t, rdb, 1, 1)
+	a.hub.Leave(second)
+	waitNumSub(t, rdb, 1, 0)
+
+	// После выхода последнего клиента можно вернуться: подписка восстанавливается
+	again := join(t, a, 1, 1)
+	waitNumSub(t, rdb, 1, 1)
+	if err := b.broker.Publish(context.Background(), 1, []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, again); got != "back" {
+		t.Fatalf("вернувшийся клиент получил %q", got)
+	}
+}
+
+func TestMarkDeliveredOnce(t *testing.T) {
+	_, replicas := newReplicas(t, 2)
+	ctx := context.Background()
+
+	first, err := replicas[0].broker.MarkDelivered(ctx, 42)
+	if err != nil || !first {
+		t.Fatalf("первая отметка доставки: %v, %v", first, err)
+	}
+	again, err := replicas[1].broker.MarkDelivered(ctx, 42)
+	if err != nil || again {
+		t.Fatalf("повторная отметка на другой реплике должна быть отклонена: %v, %v", again, err)
+	}
+	other, err := replicas[1].broker.MarkDelivered(ctx, 43)
+	if err != nil || !other {
+		t.Fatalf("отметка другого сообщения: %v, %v", other, err)
+	}
+}
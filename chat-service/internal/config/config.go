@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// WebSocketConfig - настройки WebSocket-соединений чата
+type WebSocketConfig struct {
+	// PingInterval - как часто сервер отправляет клиенту ping
+	PingInterval time.Duration
+	// PongWait - сколько ждать от клиента любого кадра или pong, прежде чем считать
+	// соединение оборванным; должно быть больше PingInterval
+	PongWait time.Duration
+	// WriteTimeout - сколько ждать записи одного кадра в соединение
+	WriteTimeout time.Duration
+	// MaxFrameSize - максимальный размер кадра от клиента в байтах; на больший кадр
+	// соединение закрывается с кодом 1009
+	MaxFrameSize int64
+	// MaxConnsPerUser, MaxConnsPerIP - сколько соединений одновременно может открыть
+	// пользователь и один IP на одной реплике; лишние закрываются с кодом 1008
+	MaxConnsPerUser int
+	MaxConnsPerIP   int
+}
+
+// LoadWebSocketConfig - читает настройки WebSocket из переменных окружения
+func LoadWebSocketConfig() (WebSocketConfig, error) {
+	cfg := WebSocketConfig{
+		PingInterval:    30 * time.Second,
+		PongWait:        60 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		MaxFrameSize:    16 << 10,
+		MaxConnsPerUser: 5,
+		MaxConnsPerIP:   20,
+	}
+
+	durations := map[string]*time.Duration{
+		"CHAT_WS_PING_INTERVAL": &cfg.PingInterval,
+		"CHAT_WS_PONG_WAIT":     &cfg.PongWait,
+		"CHAT_WS_WRITE_TIMEOUT": &cfg.WriteTimeout,
+	}
+	for env, dst := range durations {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается длительность, например 30s", env, value)
+		}
+		*dst = d
+	}
+
+	ints := map[string]*int{
+		"CHAT_WS_MAX_CONNS_PER_USER": &cfg.MaxConnsPerUser,
+		"CHAT_WS_MAX_CONNS_PER_IP":   &cfg.MaxConnsPerIP,
+	}
+	for env, dst := range ints {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается положительное число", env, value)
+		}
+		*dst = n
+	}
+
+	if value := os.Getenv("CHAT_WS_MAX_FRAME_SIZE"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("неверное значение CHAT_WS_MAX_FRAME_SIZE=%q: ожидается размер в байтах", value)
+		}
+		cfg.MaxFrameSize = n
+	}
+
+	if cfg.PongWait <= cfg.PingInterval {
+		return cfg, fmt.Errorf("CHAT_WS_PONG_WAIT (%s) должен быть больше CHAT_WS_PING_INTERVAL (%s)", cfg.PongWait, cfg.PingInterval)
+	}
+	return cfg, nil
+}
+
+// RateLimitConfig - ограничения частоты сообщений по умолчанию; на лету их меняют
+// через админское API
+type RateLimitConfig struct {
+	// UserRate, UserBurst - сколько сообщений в секунду восстанавливается у пользователя
+	// и сколько он может отправить подряд
+	UserRate  float64
+	UserBurst int
+	// ChatRate, ChatBurst - то же для всех участников одного чата вместе
+	ChatRate  float64
+	ChatBurst int
+	// DuplicateWindow - сколько не принимать от пользователя тот же текст повторно
+	DuplicateWindow time.Duration
+	// DuplicateMinLength - с какой длины (в символах) текст проверяется на повтор;
+	// короткие ответы вроде «ок» можно повторять
+	DuplicateMinLength int
+	// AdminToken - токен админского API (заголовок X-Admin-Token); пустой - API отключено
+	AdminToken string
+}
+
+// LoadRateLimitConfig - читает ограничения частоты сообщений из переменных окружения
+func LoadRateLimitConfig() (RateLimitConfig, error) {
+	cfg := RateLimitConfig{
+		UserRate:           1,
+		UserBurst:          5,
+		ChatRate:           3,
+		ChatBurst:          10,
+		DuplicateWindow:    10 * time.Second,
+		DuplicateMinLength: 20,
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+	}
+
+	floats := map[string]*float64{
+		"CHAT_RATE_USER": &cfg.UserRate,
+		"CHAT_RATE_CHAT": &cfg.ChatRate,
+	}
+	for env, dst := range floats {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f <= 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается положительное число сообщений в секунду", env, value)
+		}
+		*dst = f
+	}
+
+	ints := map[string]*int{
+		"CHAT_RATE_USER_BURST": &cfg.UserBurst,
+		"CHAT_RATE_CHAT_BURST": &cfg.ChatBurst,
+	}
+	for env, dst := range ints {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается положительное число", env, value)
+		}
+		*dst = n
+	}
+
+	if value := os.Getenv("CHAT_DUPLICATE_WINDOW"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("неверное значение CHAT_DUPLICATE_WINDOW=%q: ожидается длительность, например 10s", value)
+		}
+		cfg.DuplicateWindow = d
+	}
+
+	if value := os.Getenv("CHAT_DUPLICATE_MIN_LENGTH"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("неверное значение CHAT_DUPLICATE_MIN_LENGTH=%q: ожидается число символов", value)
+		}
+		cfg.DuplicateMinLength = n
+	}
+	return cfg, nil
+}
+
+// ChatConfig - сроки жизни сообщений и чатов
+type ChatConfig struct {
+	// EditWindow - сколько после отправки сообщение можно редактировать; удалить своё
+	// сообщение отправитель может в любое время
+	EditWindow time.Duration
+	// ArchiveAfter - через сколько неактивный или завершённый чат уходит в архив
+	ArchiveAfter time.Duration
+}
+
+// LoadChatConfig - читает сроки жизни сообщений и чатов из переменных окружения
+func LoadChatConfig() (ChatConfig, error) {
+	cfg := ChatConfig{
+		EditWindow:   15 * time.Minute,
+		ArchiveAfter: 30 * 24 * time.Hour,
+	}
+
+	durations := map[string]*time.Duration{
+		"CHAT_EDIT_WINDOW":   &cfg.EditWindow,
+		"CHAT_ARCHIVE_AFTER": &cfg.ArchiveAfter,
+	}
+	for env, dst := range durations {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается длительность, например 15m", env, value)
+		}
+		*dst = d
+	}
+	return cfg, nil
+}
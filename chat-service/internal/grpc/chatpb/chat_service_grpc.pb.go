@@ -21,6 +21,7 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	ChatService_CreateChat_FullMethodName   = "/chat.ChatService/CreateChat"
 	ChatService_GetUserChats_FullMethodName = "/chat.ChatService/GetUserChats"
+	ChatService_EndChat_FullMethodName      = "/chat.ChatService/EndChat"
 )
 
 // ChatServiceClient is the client API for ChatService service.
@@ -29,10 +30,12 @@ const (
 //
 // ChatService - сервис для управления чатами
 type ChatServiceClient interface {
-	// Создание чата между двумя пользователями
+	// Создание чата между участниками (двумя или больше для групповой комнаты)
 	CreateChat(ctx context.Context, in *CreateChatRequest, opts ...grpc.CallOption) (*CreateChatResponse, error)
 	// Получение чатов пользователя
 	GetUserChats(ctx context.Context, in *GetUserChatsRequest, opts ...grpc.CallOption) (*GetUserChatsResponse, error)
+	// Завершение чата одним из участников
+	EndChat(ctx context.Context, in *EndChatRequest, opts ...grpc.CallOption) (*EndChatResponse, error)
 }
 
 type chatServiceClient struct {
@@ -63,16 +66,28 @@ func (c *chatServiceClient) GetUserChats(ctx context.Context, in *GetUserChatsRe
 	return out, nil
 }
 
+func (c *chatServiceClient) EndChat(ctx context.Context, in *EndChatRequest, opts ...grpc.CallOption) (*EndChatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EndChatResponse)
+	err := c.cc.Invoke(ctx, ChatService_EndChat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ChatServiceServer is the server API for ChatService service.
 // All implementations must embed UnimplementedChatServiceServer
 // for forward compatibility.
 //
 // ChatService - сервис для управления чатами
 type ChatServiceServer interface {
-	// Создание чата между двумя пользователями
+	// Создание чата между участниками (двумя или больше для групповой комнаты)
 	CreateChat(context.Context, *CreateChatRequest) (*CreateChatResponse, error)
 	// Получение чатов пользователя
 	GetUserChats(context.Context, *GetUserChatsRequest) (*GetUserChatsResponse, error)
+	// Завершение чата одним из участников
+	EndChat(context.Context, *EndChatRequest) (*EndChatResponse, error)
 	mustEmbedUnimplementedChatServiceServer()
 }
 
@@ -89,6 +104,9 @@ func (UnimplementedChatServiceServer) CreateChat(context.Context, *CreateChatReq
 func (UnimplementedChatServiceServer) GetUserChats(context.Context, *GetUserChatsRequest) (*GetUserChatsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetUserChats not implemented")
 }
+func (UnimplementedChatServiceServer) EndChat(context.Context, *EndChatRequest) (*EndChatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EndChat not implemented")
+}
 func (UnimplementedChatServiceServer) mustEmbedUnimplementedChatServiceServer() {}
 func (UnimplementedChatServiceServer) testEmbeddedByValue()                     {}
 
@@ -146,6 +164,24 @@ func _ChatService_GetUserChats_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ChatService_EndChat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EndChatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChatServiceServer).EndChat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChatService_EndChat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChatServiceServer).EndChat(ctx, req.(*EndChatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ChatService_ServiceDesc is the grpc.ServiceDesc for ChatService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,6 +197,10 @@ var ChatService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetUserChats",
 			Handler:    _ChatService_GetUserChats_Handler,
 		},
+		{
+			MethodName: "EndChat",
+			Handler:    _ChatService_EndChat_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/chat_service.proto",
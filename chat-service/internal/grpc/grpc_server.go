@@ -28,6 +28,16 @@ func (s *ChatServer) CreateChat(ctx context.Context, req *chatpb.CreateChatReque
 	return s.chatService.CreateChat(ctx, req)
 }
 
+// GetUserChats - обработка gRPC-запроса списка чатов пользователя
+func (s *ChatServer) GetUserChats(ctx context.Context, req *chatpb.GetUserChatsRequest) (*chatpb.GetUserChatsResponse, error) {
+	return s.chatService.GetUserChats(ctx, req)
+}
+
+// EndChat - обработка gRPC-запроса завершения чата
+func (s *ChatServer) EndChat(ctx context.Context, req *chatpb.EndChatRequest) (*chatpb.EndChatResponse, error) {
+	return s.chatService.EndChat(ctx, req)
+}
+
 func RunGRPCServer(chatService *service.ChatService) {
 	listener, err := net.Listen("tcp", ":50051")
 	if err != nil {
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"crypto/subtle"
+
+	"chat-service/internal/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminHandler - админское API chat-service
+type AdminHandler struct {
+	limiter *ratelimit.Limiter
+}
+
+func NewAdminHandler(limiter *ratelimit.Limiter) *AdminHandler {
+	return &AdminHandler{limiter: limiter}
+}
+
+// RequireAdminToken - пропускает запрос, только если X-Admin-Token совпадает с token.
+// С пустым token админское API отключено.
+func RequireAdminToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Админское API отключено"})
+		}
+		if subtle.ConstantTimeCompare([]byte(c.Get("X-Admin-Token")), []byte(token)) != 1 {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Неверный X-Admin-Token"})
+		}
+		return c.Next()
+	}
+}
+
+// GetRateLimits - действующие ограничения частоты сообщений
+func (h *AdminHandler) GetRateLimits(c *fiber.Ctx) error {
+	limits, err := h.limiter.Limits(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(limits)
+}
+
+// SetRateLimits - меняет ограничения частоты сообщений на всех репликах без перезапуска.
+// Поля, которых нет в запросе, остаются прежними.
+func (h *AdminHandler) SetRateLimits(c *fiber.Ctx) error {
+	limits, err := h.limiter.Limits(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := c.BodyParser(&limits); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+	if err := limits.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := h.limiter.SetLimits(c.Context(), limits); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(limits)
+}
@@ -3,105 +3,793 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"strconv"
 	"time"
 
+	"chat-service/internal/bot"
+	"chat-service/internal/broker"
+	"chat-service/internal/config"
+	"chat-service/internal/hub"
+	"chat-service/internal/lifecycle"
+	"chat-service/internal/presence"
+	"chat-service/internal/ratelimit"
 	"chat-service/internal/repository"
 	"chat-service/pkg/models"
+	"chat-service/pkg/protocol"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
+const (
+	// botReplyTimeout - сколько ждать ответа бота
+	botReplyTimeout = 5 * time.Second
+	// Размер страницы истории по умолчанию и максимальный
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 100
+	// deliveryTimeout - сколько ждать отметки доставки в Redis
+	deliveryTimeout = 2 * time.Second
+	// subscribeTimeout - сколько ждать подписки реплики на события чата в Redis
+	subscribeTimeout = 2 * time.Second
+	// maxClientIDLength - максимальная длина client_id сообщения
+	maxClientIDLength = 64
+	// maxReplayMessages - сколько пропущенных сообщений досылается при переподключении. Пока они
+	// пишутся в соединение, живые события копятся в очереди клиента (wsSendBuffer = 64), поэтому
+	// досылка должна быть меньше очереди; остальное клиент догружает кадрами history с after
+	maxReplayMessages = 50
+)
+
+// wsAuthSubprotocol - подпротокол, в паре с которым браузер передаёт токен при открытии
+// WebSocket: new WebSocket(url, ["bearer", token]). Сам токен проверяет шлюз.
+const wsAuthSubprotocol = "bearer"
+
+// WebSocketConfig - настройки WebSocket чата: сервер подтверждает версию протокола, а клиентам
+// без версии - подпротокол авторизации, иначе браузер закроет соединение
+var WebSocketConfig = websocket.Config{Subprotocols: []string{protocol.Subprotocol, wsAuthSubprotocol}}
+
 type ChatHandler struct {
-	chatRepo *repository.ChatRepository
-	clients  map[int64]map[*websocket.Conn]bool // WebSocket-клиенты (chat_id -> conn)
+	chatRepo   *repository.ChatRepository
+	bots       *bot.Registry
+	hub        *hub.Hub             // WebSocket-клиенты этой реплики по комнатам чатов
+	broker     *broker.Broker       // события чатов для всех реплик
+	presence   *presence.Tracker    // кто из пользователей в сети на всех репликах
+	limiter    *ratelimit.Limiter   // частота сообщений пользователей и чатов на всех репликах
+	lifecycle  *lifecycle.Publisher // события о завершении чатов для других сервисов
+	editWindow time.Duration        // сколько после отправки сообщение можно редактировать
+	ws         config.WebSocketConfig
+	conns      *connLimits // открытые соединения этой реплики
 }
 
-func NewChatHandler(chatRepo *repository.ChatRepository) *ChatHandler {
+func NewChatHandler(chatRepo *repository.ChatRepository, bots *bot.Registry, chatHub *hub.Hub, chatBroker *broker.Broker, tracker *presence.Tracker, limiter *ratelimit.Limiter, publisher *lifecycle.Publisher, editWindow time.Duration, wsCfg config.WebSocketConfig) *ChatHandler {
 	return &ChatHandler{
-		chatRepo: chatRepo,
-		clients:  make(map[int64]map[*websocket.Conn]bool),
+		chatRepo:   chatRepo,
+		bots:       bots,
+		hub:        chatHub,
+		broker:     chatBroker,
+		presence:   tracker,
+		limiter:    limiter,
+		lifecycle:  publisher,
+		editWindow: editWindow,
+		ws:         wsCfg,
+		conns:      newConnLimits(wsCfg.MaxConnsPerUser, wsCfg.MaxConnsPerIP),
 	}
 }
 
+// userIDFromRequest - читает X-User-ID, выставленный шлюзом после проверки JWT.
+// При ошибке сам отправляет ответ клиенту и возвращает false.
+func userIDFromRequest(c *fiber.Ctx) (int64, bool) {
+	userIDStr := c.Get("X-User-ID")
+	if userIDStr == "" {
+		c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Необходимо передать X-User-ID"})
+		return 0, false
+	}
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат X-User-ID"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// AuthorizeWebSocket - проверяет до открытия WebSocket, что это запрос на upgrade
+// и что пользователь участвует в чате. Пользователь и чат передаются обработчику через Locals.
+func (h *ChatHandler) AuthorizeWebSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	if !protocol.Supported(c.Get(fiber.HeaderSecWebSocketProtocol)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неподдерживаемая версия протокола, сервер поддерживает " + protocol.Subprotocol})
+	}
+
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+	chat, ok := h.memberChat(c, userID)
+	if !ok {
+		return nil
+	}
+	// last_id - последнее сообщение, которое клиент видел до обрыва связи
+	var lastID int64
+	if value := c.Query("last_id"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный параметр last_id"})
+		}
+		lastID = n
+	}
+
+	c.Locals("userID", userID)
+	c.Locals("chat", chat)
+	c.Locals("lastID", lastID)
+	return c.Next()
+}
+
+// memberChat - чат из параметра :chat_id (публичный ID), если пользователь в нём участвует.
+// Для чужих чатов отвечает 404, как и для несуществующих, чтобы не раскрывать их наличие.
+// При ошибке сам отправляет ответ клиенту и возвращает false.
+func (h *ChatHandler) memberChat(c *fiber.Ctx, userID int64) (*models.Chat, bool) {
+	chat, err := h.chatRepo.GetMemberChat(c.Context(), c.Params("chat_id"), userID)
+	if errors.Is(err, repository.ErrChatNotFound) {
+		c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Чат не найден"})
+		return nil, false
+	}
+	if err != nil {
+		c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return nil, false
+	}
+	return chat, true
+}
+
+// WebSocketHandler - обмен сообщениями в чате. Вызывается только после AuthorizeWebSocket:
+// отправитель сообщений - авторизованный пользователь, а не поле из JSON клиента.
 func (h *ChatHandler) WebSocketHandler(c *websocket.Conn) {
 	defer c.Close()
 
-	chatIDStr := c.Params("chat_id")
-	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
-	if err != nil {
-		log.Println("❌ Ошибка: Неверный chat_id")
+	userID := c.Locals("userID").(int64)
+	chat := c.Locals("chat").(*models.Chat)
+	lastID := c.Locals("lastID").(int64)
+	chatID := chat.ID
+
+	ip := c.IP()
+	if err := h.conns.acquire(userID, ip); err != nil {
+		log.Printf("🚫 Пользователь %d (%s) превысил лимит соединений: %v", userID, ip, err)
+		h.closeWith(c, websocket.ClosePolicyViolation, err.Error())
 		return
 	}
+	defer h.conns.release(userID, ip)
+	// На кадр больше лимита библиотека сама закрывает соединение с кодом 1009
+	c.SetReadLimit(h.ws.MaxFrameSize)
 
-	if h.clients[chatID] == nil {
-		h.clients[chatID] = make(map[*websocket.Conn]bool)
+	events := []interface{}{models.WelcomeEvent{Event: "welcome", Version: protocol.Version}}
+	var chatBot bot.Bot
+	if chat.IsBotChat() {
+		b, ok := h.bots.Get(chat.BotName)
+		if !ok {
+			log.Printf("❌ Бот %q чата %d не найден", chat.BotName, chatID)
+			return
+		}
+		chatBot = b
+		events = append(events, models.BotChatEvent{Event: "bot_chat", ChatID: chat.PublicID, Bot: chat.BotName})
+	}
+
+	// Клиент входит в комнату до загрузки пропущенных сообщений: новые сообщения копятся
+	// в его очереди, пока пропущенные пишутся в соединение напрямую, поэтому разрыва нет.
+	// Дальше в соединение пишет только writePump.
+	client := h.hub.Join(chatID, userID)
+	// Первому клиенту чата на реплике нужна подписка на его события в Redis
+	subscribeCtx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+	if err := h.broker.WaitSubscribed(subscribeCtx, chatID); err != nil {
+		log.Printf("⚠️ %v", err)
 	}
-	h.clients[chatID][c] = true
-	log.Printf("✅ Пользователь подключился к чату %d", chatID)
+	cancel()
+	replayed, err := h.writeInitialFrames(c, client, events, lastID)
+	if err != nil {
+		log.Printf("❌ Ошибка подключения к чату %d: %v", chatID, err)
+		h.hub.Leave(client)
+		return
+	}
+	// Клиент, не ответивший на ping за PongWait, считается пропавшим
+	c.SetReadDeadline(time.Now().Add(h.ws.PongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(h.ws.PongWait))
+	})
+	pumpDone := make(chan struct{})
+	go func() {
+		h.writePump(c, client, replayed)
+		close(pumpDone)
+	}()
+	typing := newTypingState(func() { h.publishTyping(client, false) })
+	disconnectPresence := h.connectPresence(client)
+	h.sendPartnerPresence(client, chat)
+	// Контекст соединения: фоновая работа для клиента (ответы бота) прекращается при отключении
+	connCtx, cancelConn := context.WithCancel(context.Background())
+	// После выхода из обработчика соединение возвращается в пул, поэтому дожидаемся writePump
+	defer func() {
+		cancelConn()
+		if typing.stop() {
+			h.publishTyping(client, false)
+		}
+		disconnectPresence()
+		h.hub.Leave(client)
+		<-pumpDone
+	}()
+	log.Printf("✅ Пользователь %d подключился к чату %d", userID, chatID)
 
 	for {
 		_, raw, err := c.ReadMessage()
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("⏱️ Клиент пользователя %d не отвечает, отключаем от чата %d", userID, chatID)
+				h.closeWith(c, websocket.CloseGoingAway, "нет ответа на ping")
+				break
+			}
 			log.Printf("❌ Отключение клиента от чата %d: %v", chatID, err)
-			delete(h.clients[chatID], c)
 			break
 		}
+		c.SetReadDeadline(time.Now().Add(h.ws.PongWait))
 
-		var wsMsg models.WsMessage
-		if err := json.Unmarshal(raw, &wsMsg); err != nil {
-			log.Println("❌ Неверный формат WS-сообщения:", err)
+		env, err := protocol.Decode(raw)
+		if err != nil {
+			h.hub.SendTo(client, protocol.EncodeError("", protocol.ErrBadFrame, err.Error()))
 			continue
 		}
 
-		wsMsg.Timestamp = time.Now().UTC()
+		switch env.Type {
+		case protocol.TypeMessage:
+			h.handleMessage(connCtx, client, chatID, chatBot, env)
+			// Отправленное сообщение завершает набор
+			if typing.stop() {
+				h.publishTyping(client, false)
+			}
+		case protocol.TypeTyping:
+			h.handleTyping(client, typing, env)
+		case protocol.TypeRead:
+			h.handleRead(client, env)
+		case protocol.TypeEdit:
+			h.handleEdit(client, env)
+		case protocol.TypeDelete:
+			h.handleDelete(client, env)
+		case protocol.TypeHistory:
+			h.sendHistory(client, chatID, env)
+		default:
+			h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrUnsupportedType, "Неподдерживаемый тип кадра "+env.Type))
+		}
+	}
+}
+
+// writeInitialFrames - пишет в соединение служебные события и, если клиент передал last_id,
+// первые maxReplayMessages сообщений после него. Возвращает ID досланных сообщений: они могли попасть и в очередь
+// клиента, и writePump их пропустит.
+func (h *ChatHandler) writeInitialFrames(c *websocket.Conn, client *hub.Client, events []interface{}, lastID int64) (map[int64]struct{}, error) {
+	for _, event := range events {
+		if err := h.writeFrame(c, protocol.TypeSystem, event); err != nil {
+			return nil, err
+		}
+	}
+	if lastID == 0 {
+		return nil, nil
+	}
+
+	page, err := h.chatRepo.GetChatHistory(context.Background(), client.ChatID(), repository.HistoryQuery{AfterID: lastID, Limit: maxReplayMessages})
+	if err != nil {
+		return nil, err
+	}
+	replayed := make(map[int64]struct{}, len(page.Messages))
+	for _, msg := range page.Messages {
+		if err := h.writeFrame(c, protocol.TypeMessage, msg); err != nil {
+			return nil, err
+		}
+		replayed[msg.ID] = struct{}{}
+		h.confirmDelivery(client, &msg)
+	}
+	resumed := models.ResumedEvent{Event: "resumed", Replayed: len(page.Messages), NextCursor: page.NextCursor}
+
+	if err := h.writeFrame(c, protocol.TypeSystem, resumed); err != nil {
+		return nil, err
+	}
+	log.Printf("🔁 Пользователю %d дослано %d сообщений чата %d", client.UserID, resumed.Replayed, client.ChatID())
+	return replayed, nil
+}
+
+// writeFrame - пишет кадр прямо в соединение; допустимо только до запуска writePump
+func (h *ChatHandler) writeFrame(c *websocket.Conn, frameType string, payload interface{}) error {
+	frame, err := protocol.Encode(frameType, "", payload)
+	if err != nil {
+		return err
+	}
+	c.SetWriteDeadline(time.Now().Add(h.ws.WriteTimeout))
+	if err := c.WriteMessage(websocket.TextMessage, frame); err != nil {
+		return fmt.Errorf("ошибка отправки кадра %s: %w", frameType, err)
+	}
+	return nil
+}
+
+// closeWith - отправляет клиенту кадр закрытия с кодом и причиной. Кадры управления можно
+// отправлять параллельно с writePump.
+func (h *ChatHandler) closeWith(c *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(h.ws.WriteTimeout)); err != nil {
+		log.Printf("❌ Ошибка закрытия соединения с кодом %d: %v", code, err)
+	}
+}
+
+// handleMessage - сохраняет сообщение клиента, подтверждает его отправителю кадром ack
+// и рассылает участникам чата. Ответ бота готовится в фоне, пока жив connCtx.
+func (h *ChatHandler) handleMessage(connCtx context.Context, client *hub.Client, chatID int64, chatBot bot.Bot, env *protocol.Envelope) {
+	var payload models.SendMessagePayload
+	if err := env.DecodePayload(&payload); err != nil {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, err.Error()))
+		return
+	}
+	if payload.Content == "" {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, "Пустое сообщение"))
+		return
+	}
+	if len(payload.ClientID) > maxClientIDLength {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, "Слишком длинный client_id"))
+		return
+	}
+	// Переотправка уже сохранённого сообщения после обрыва связи не расходует лимит:
+	// клиенту достаточно ack
+	if payload.ClientID != "" {
+		existing, err := h.chatRepo.FindMessageByClientID(context.Background(), chatID, client.UserID, payload.ClientID)
+		if err != nil {
+			log.Println("❌", err)
+		} else if existing != nil {
+			h.ackSent(client, env.ID, existing, payload.ClientID)
+			return
+		}
+	}
+	if !h.allowMessage(client, env.ID, payload) {
+		return
+	}
+
+	modelMsg := models.Message{
+		ChatID:    chatID,
+		SenderID:  client.UserID,
+		Content:   payload.Content,
+		CreatedAt: time.Now().UTC(),
+	}
+	if payload.ClientID != "" {
+		modelMsg.ClientID = &payload.ClientID
+	}
+	duplicate, err := h.chatRepo.SaveMessage(context.Background(), &modelMsg)
+	if errors.Is(err, repository.ErrChatClosed) {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrChatClosed, err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Ошибка сохранения сообщения: %v", err)
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInternal, "Ошибка сохранения сообщения"))
+		return
+	}
+
+	h.ackSent(client, env.ID, &modelMsg, payload.ClientID)
+	// Повтор уже сохранённого сообщения: участники его получили, клиенту достаточно ack
+	if duplicate {
+		return
+	}
+	h.broadcast(chatID, modelMsg)
 
-		modelMsg := models.Message{
-			ChatID:    chatID,
-			SenderID:  wsMsg.SenderID,
-			Content:   wsMsg.Content,
-			CreatedAt: wsMsg.Timestamp,
+	if chatBot != nil {
+		// Бот может отвечать до botReplyTimeout - цикл чтения не должен его ждать
+		go h.replyAsBot(connCtx, chatBot, modelMsg)
+	}
+}
+
+// ackSent - подтверждает отправителю, что сообщение сохранено
+func (h *ChatHandler) ackSent(client *hub.Client, id string, msg *models.Message, clientID string) {
+	h.sendFrame(client, protocol.TypeAck, id, models.AckPayload{
+		MessageID: msg.ID,
+		ClientID:  clientID,
+		Status:    models.AckSent,
+		CreatedAt: msg.CreatedAt,
+	})
+}
+
+// handleRead - кадр read от клиента: сдвигает курсор прочтения и уведомляет собеседников
+func (h *ChatHandler) handleRead(client *hub.Client, env *protocol.Envelope) {
+	var payload models.ReadPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, err.Error()))
+		return
+	}
+	if err := h.markRead(client.ChatID(), client.UserID, payload.MessageID); err != nil {
+		code := protocol.ErrInternal
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			code = protocol.ErrInvalidPayload
 		}
-		if err := h.chatRepo.SaveMessage(context.Background(), &modelMsg); err != nil {
-			log.Printf("❌ Ошибка сохранения сообщения: %v", err)
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, code, err.Error()))
+	}
+}
+
+// markRead - сдвигает курсор прочтения участника и, если он сдвинулся, рассылает в чат кадр read
+func (h *ChatHandler) markRead(chatID, userID, messageID int64) error {
+	moved, err := h.chatRepo.MarkRead(context.Background(), chatID, userID, messageID)
+	if err != nil {
+		return err
+	}
+	if moved {
+		h.publish(chatID, protocol.TypeRead, models.ReadPayload{MessageID: messageID, UserID: userID})
+	}
+	return nil
+}
+
+// handleEdit - кадр edit от клиента: меняет текст его сообщения и рассылает изменённое сообщение
+func (h *ChatHandler) handleEdit(client *hub.Client, env *protocol.Envelope) {
+	var payload models.EditMessagePayload
+	if err := env.DecodePayload(&payload); err != nil {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, err.Error()))
+		return
+	}
+	if payload.Content == "" {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, "Пустое сообщение"))
+		return
+	}
+
+	msg, err := h.chatRepo.EditMessage(context.Background(), client.ChatID(), payload.MessageID, client.UserID, payload.Content, h.editWindow)
+	if err != nil {
+		h.sendMessageError(client, env.ID, err)
+		return
+	}
+	h.publish(client.ChatID(), protocol.TypeEdit, msg)
+}
+
+// handleDelete - кадр delete от клиента: удаляет его сообщение и рассылает надгробие
+func (h *ChatHandler) handleDelete(client *hub.Client, env *protocol.Envelope) {
+	var payload models.DeleteMessagePayload
+	if err := env.DecodePayload(&payload); err != nil {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, err.Error()))
+		return
+	}
+
+	msg, err := h.chatRepo.DeleteMessage(context.Background(), client.ChatID(), payload.MessageID, client.UserID)
+	if err != nil {
+		h.sendMessageError(client, env.ID, err)
+		return
+	}
+	h.publish(client.ChatID(), protocol.TypeDelete, msg)
+}
+
+// sendMessageError - кадр error в ответ на неудачное редактирование или удаление сообщения
+func (h *ChatHandler) sendMessageError(client *hub.Client, id string, err error) {
+	code := protocol.ErrInternal
+	switch {
+	case errors.Is(err, repository.ErrMessageNotFound):
+		code = protocol.ErrNotFound
+	case errors.Is(err, repository.ErrNotMessageAuthor),
+		errors.Is(err, repository.ErrMessageDeleted),
+		errors.Is(err, repository.ErrEditWindowClosed):
+		code = protocol.ErrForbidden
+	case errors.Is(err, repository.ErrChatClosed):
+		code = protocol.ErrChatClosed
+	default:
+		log.Printf("❌ Ошибка изменения сообщения: %v", err)
+	}
+	h.hub.SendTo(client, protocol.EncodeError(id, code, err.Error()))
+}
+
+// sendHistory - отправляет клиенту страницу истории, запрошенную кадром history
+func (h *ChatHandler) sendHistory(client *hub.Client, chatID int64, env *protocol.Envelope) {
+	// payload необязателен: без него отдаётся последняя страница
+	var req models.HistoryRequestPayload
+	if len(env.Payload) > 0 {
+		if err := env.DecodePayload(&req); err != nil {
+			h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, err.Error()))
+			return
+		}
+	}
+	q, err := historyQuery(req.Before, req.After, req.Limit)
+	if err != nil {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, err.Error()))
+		return
+	}
+
+	page, err := h.chatRepo.GetChatHistory(context.Background(), chatID, q)
+	if err != nil {
+		log.Printf("❌ Ошибка загрузки истории чата %d: %v", chatID, err)
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInternal, "Ошибка загрузки истории чата"))
+		return
+	}
+	h.sendFrame(client, protocol.TypeHistory, env.ID, page)
+}
+
+// sendFrame - отправляет кадр одному клиенту
+func (h *ChatHandler) sendFrame(client *hub.Client, frameType, id string, payload interface{}) {
+	frame, err := protocol.Encode(frameType, id, payload)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	h.hub.SendTo(client, frame)
+}
+
+// historyQuery - проверяет параметры страницы истории; limit 0 - размер по умолчанию
+func historyQuery(before, after int64, limit int) (repository.HistoryQuery, error) {
+	switch {
+	case before < 0 || after < 0:
+		return repository.HistoryQuery{}, errors.New("курсор не может быть отрицательным")
+	case before > 0 && after > 0:
+		return repository.HistoryQuery{}, errors.New("нельзя одновременно передать before и after")
+	case limit < 0:
+		return repository.HistoryQuery{}, errors.New("limit не может быть отрицательным")
+	case limit == 0:
+		limit = defaultHistoryLimit
+	case limit > maxHistoryLimit:
+		limit = maxHistoryLimit
+	}
+	return repository.HistoryQuery{BeforeID: before, AfterID: after, Limit: limit}, nil
+}
+
+// replyAsBot - сохраняет и рассылает ответ бота на сообщение пользователя; ответ
+// отменяется, если соединение пользователя закрылось раньше
+func (h *ChatHandler) replyAsBot(connCtx context.Context, b bot.Bot, msg models.Message) {
+	ctx, cancel := context.WithTimeout(connCtx, botReplyTimeout)
+	defer cancel()
+
+	reply, err := b.Reply(ctx, msg.ChatID, msg.Content)
+	if err != nil {
+		log.Printf("❌ Ошибка ответа бота %q в чате %d: %v", b.Name(), msg.ChatID, err)
+		return
+	}
+	if reply == "" {
+		return
+	}
+
+	botMsg := models.Message{
+		ChatID:    msg.ChatID,
+		Content:   reply,
+		CreatedAt: time.Now().UTC(),
+		FromBot:   true,
+	}
+	if _, err := h.chatRepo.SaveMessage(ctx, &botMsg); err != nil {
+		log.Printf("❌ Ошибка сохранения сообщения бота: %v", err)
+		return
+	}
+	h.broadcast(msg.ChatID, botMsg)
+}
+
+// writePump - единственный писатель в соединение: отправляет сообщения из очереди клиента,
+// пропуская уже досланные при подключении (skip), и раз в PingInterval - ping. Очередь
+// закрывается, когда клиент покинул комнату или хаб отключил его как медленного; тогда
+// (или при ошибке записи) соединение закрывается, и цикл чтения тоже завершается.
+func (h *ChatHandler) writePump(c *websocket.Conn, client *hub.Client, skip map[int64]struct{}) {
+	defer c.Close()
+	ping := time.NewTicker(h.ws.PingInterval)
+	defer ping.Stop()
+	for {
+		var payload []byte
+		select {
+		case <-ping.C:
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(h.ws.WriteTimeout)); err != nil {
+				log.Println("❌ Ошибка отправки ping:", err)
+				return
+			}
 			continue
+		case p, ok := <-client.Send():
+			if !ok {
+				return
+			}
+			payload = p
 		}
 
-		for client := range h.clients[chatID] {
-			if err := client.WriteJSON(modelMsg); err != nil {
-				log.Println("❌ Ошибка отправки JSON-сообщения:", err)
+		var msg *models.Message
+		if protocol.HasType(payload, protocol.TypeMessage) {
+			msg = decodeMessageFrame(payload)
+		}
+		if msg != nil {
+			if _, ok := skip[msg.ID]; ok {
+				continue
 			}
 		}
+		if (protocol.HasType(payload, protocol.TypeTyping) || protocol.HasType(payload, protocol.TypePresence)) &&
+			isOwnEvent(client, payload) {
+			continue
+		}
+
+		c.SetWriteDeadline(time.Now().Add(h.ws.WriteTimeout))
+		if err := c.WriteMessage(websocket.TextMessage, payload); err != nil {
+			log.Println("❌ Ошибка отправки сообщения:", err)
+			return
+		}
+		if msg != nil {
+			h.confirmDelivery(client, msg)
+		}
+	}
+}
+
+// decodeMessageFrame - сообщение из кадра message; nil, если кадр не разобрать
+func decodeMessageFrame(frame []byte) *models.Message {
+	var env struct {
+		Payload models.Message `json:"payload"`
+	}
+	if err := json.Unmarshal(frame, &env); err != nil {
+		log.Println("❌ Ошибка разбора кадра сообщения:", err)
+		return nil
 	}
+	return &env.Payload
 }
 
+// confirmDelivery - после записи чужого сообщения в соединение рассылает в чат ack со
+// статусом delivered. Подтверждение отправляется один раз, кто бы из собеседников
+// ни получил сообщение первым.
+func (h *ChatHandler) confirmDelivery(client *hub.Client, msg *models.Message) {
+	if msg.FromBot || msg.SystemEvent != "" || msg.SenderID == client.UserID {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	first, err := h.broker.MarkDelivered(ctx, msg.ID)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	if !first {
+		return
+	}
+
+	ack := models.AckPayload{MessageID: msg.ID, Status: models.AckDelivered, CreatedAt: msg.CreatedAt}
+	if msg.ClientID != nil {
+		ack.ClientID = *msg.ClientID
+	}
+	h.publish(client.ChatID(), protocol.TypeAck, ack)
+}
+
+// broadcast - отправляет сообщение всем WebSocket-клиентам чата на всех репликах
+func (h *ChatHandler) broadcast(chatID int64, msg models.Message) {
+	h.publish(chatID, protocol.TypeMessage, msg)
+}
+
+// NotifyMessage - отправляет сообщение всем WebSocket-клиентам чата на всех репликах
+func (h *ChatHandler) NotifyMessage(chatID int64, msg models.Message) {
+	h.broadcast(chatID, msg)
+}
+
+// NotifyChat - отправляет служебное событие всем WebSocket-клиентам чата на всех репликах
+func (h *ChatHandler) NotifyChat(chatID int64, event interface{}) {
+	h.publish(chatID, protocol.TypeSystem, event)
+}
+
+// publish - рассылает кадр всем WebSocket-клиентам чата на всех репликах
+func (h *ChatHandler) publish(chatID int64, frameType string, payload interface{}) {
+	frame, err := protocol.Encode(frameType, "", payload)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	if err := h.broker.Publish(context.Background(), chatID, frame); err != nil {
+		log.Println("❌ Ошибка отправки события:", err)
+	}
+}
+
+// GetProtocolSchema - JSON Schema кадров WebSocket чата
+func (h *ChatHandler) GetProtocolSchema(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.Send(protocol.Schema())
+}
+
+// GetChatHistory - страница истории сообщений чата (?before= или ?after= ID сообщения, ?limit=);
+// доступна только участникам чата
 func (h *ChatHandler) GetChatHistory(c *fiber.Ctx) error {
-	chatID, err := strconv.ParseInt(c.Params("chat_id"), 10, 64)
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+	chat, ok := h.memberChat(c, userID)
+	if !ok {
+		return nil
+	}
+
+	var params [3]int64
+	for i, name := range []string{"before", "after", "limit"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный параметр " + name})
+		}
+		params[i] = n
+	}
+	q, err := historyQuery(params[0], params[1], int(params[2]))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный chat_id"})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	messages, err := h.chatRepo.GetChatHistory(context.Background(), chatID)
+	page, err := h.chatRepo.GetChatHistory(context.Background(), chat.ID, q)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Ошибка загрузки истории чата"})
 	}
 
-	return c.JSON(messages)
+	return c.JSON(page)
 }
 
-func (h *ChatHandler) GetAllChats(c *fiber.Ctx) error {
-	userIDStr := c.Get("X-User-ID")
-	if userIDStr == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Необходимо передать X-User-ID"})
+// MarkRead - отмечает сообщения чата прочитанными до message_id включительно
+func (h *ChatHandler) MarkRead(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+	chat, ok := h.memberChat(c, userID)
+	if !ok {
+		return nil
+	}
+
+	var req models.ReadPayload
+	if err := c.BodyParser(&req); err != nil || req.MessageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+	err := h.markRead(chat.ID, userID, req.MessageID)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Сообщение не найдено"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Сообщения отмечены прочитанными"})
+}
+
+// leaveReason - причина завершения чата, когда участник сам вышел из него
+const leaveReason = "left"
+
+// LeaveChat - участник выходит из чата: чат завершается, остальные участники получают
+// служебное сообщение и событие chat_ended. Повторный выход ничего не меняет.
+func (h *ChatHandler) LeaveChat(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+	chat, ok := h.memberChat(c, userID)
+	if !ok {
+		return nil
+	}
+
+	chat, notice, err := h.chatRepo.EndChat(c.Context(), chat.ID, userID, leaveReason)
+	if errors.Is(err, repository.ErrChatNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Чат не найден"})
 	}
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат X-User-ID"})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if notice != nil {
+		h.NotifyMessage(chat.ID, *notice)
+		h.NotifyChat(chat.ID, models.ChatEndedEvent{
+			Event:   "chat_ended",
+			ChatID:  chat.PublicID,
+			Reason:  chat.EndReason,
+			EndedAt: *chat.EndedAt,
+		})
+		if err := h.lifecycle.ChatEnded(c.Context(), lifecycle.EndedByMember(chat)); err != nil {
+			log.Println("❌", err)
+		}
+	}
+	return c.JSON(fiber.Map{"message": "Вы вышли из чата"})
+}
+
+// GetAllChats - чаты пользователя с числом непрочитанных и последним сообщением;
+// ?state= оставляет только чаты в указанном состоянии
+func (h *ChatHandler) GetAllChats(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+	state := c.Query("state")
+	switch state {
+	case "", models.ChatActive, models.ChatEnded, models.ChatArchived:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный параметр state"})
 	}
 
-	chats, err := h.chatRepo.GetUserChats(context.Background(), userID)
+	chats, err := h.chatRepo.GetUserChats(context.Background(), userID, state)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
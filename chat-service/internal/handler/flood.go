@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chat-service/internal/hub"
+	"chat-service/internal/ratelimit"
+	"chat-service/pkg/models"
+	"chat-service/pkg/protocol"
+)
+
+// rateLimitTimeout - сколько ждать проверки лимита в Redis
+const rateLimitTimeout = 2 * time.Second
+
+// allowMessage - проверяет сообщение клиента по лимитам частоты до сохранения в БД.
+// Отклонённое сообщение получает кадр error со временем, через которое его можно повторить.
+// Если Redis недоступен, сообщения пропускаются: чат не должен остановиться из-за лимитера.
+func (h *ChatHandler) allowMessage(client *hub.Client, id string, payload models.SendMessagePayload) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitTimeout)
+	defer cancel()
+	decision, err := h.limiter.Allow(ctx, client.UserID, client.ChatID(), payload.Content, payload.ClientID)
+	if err != nil {
+		log.Println("❌", err)
+		return true
+	}
+	if decision.Allowed {
+		return true
+	}
+
+	errPayload := protocol.ErrorPayload{Code: protocol.ErrSlowDown, RetryAfterMs: decision.RetryAfter.Milliseconds()}
+	switch decision.Reason {
+	case ratelimit.ReasonDuplicate:
+		errPayload.Code, errPayload.Message = protocol.ErrDuplicate, "Это сообщение уже отправлено"
+	case ratelimit.ReasonChat:
+		errPayload.Message = "В чат пишут слишком часто, подождите"
+	default:
+		errPayload.Message = "Слишком много сообщений, подождите"
+	}
+	log.Printf("🐌 Сообщение пользователя %d в чат %d отклонено (%s), повтор через %s", client.UserID, client.ChatID(), decision.Reason, decision.RetryAfter)
+	h.sendFrame(client, protocol.TypeError, id, errPayload)
+	return false
+}
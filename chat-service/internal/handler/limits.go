@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+)
+
+// connLimits - число открытых WebSocket-соединений этой реплики по пользователям и IP
+type connLimits struct {
+	mu         sync.Mutex
+	perUser    map[int64]int
+	perIP      map[string]int
+	maxPerUser int
+	maxPerIP   int
+}
+
+func newConnLimits(maxPerUser, maxPerIP int) *connLimits {
+	return &connLimits{
+		perUser:    make(map[int64]int),
+		perIP:      make(map[string]int),
+		maxPerUser: maxPerUser,
+		maxPerIP:   maxPerIP,
+	}
+}
+
+// acquire - учитывает новое соединение. Возвращает ошибку, если пользователь или IP
+// уже открыли максимум соединений; тогда соединение не учитывается.
+func (l *connLimits) acquire(userID int64, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perUser[userID] >= l.maxPerUser {
+		return fmt.Errorf("не больше %d соединений на пользователя", l.maxPerUser)
+	}
+	if l.perIP[ip] >= l.maxPerIP {
+		return fmt.Errorf("не больше %d соединений с одного IP", l.maxPerIP)
+	}
+	l.perUser[userID]++
+	l.perIP[ip]++
+	return nil
+}
+
+// release - соединение, учтённое acquire, закрыто
+func (l *connLimits) release(userID int64, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perUser[userID]--; l.perUser[userID] <= 0 {
+		delete(l.perUser, userID)
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chat-service/internal/hub"
+	"chat-service/pkg/models"
+	"chat-service/pkg/protocol"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// presenceTimeout - сколько ждать Redis и БД при учёте присутствия
+const presenceTimeout = 2 * time.Second
+
+// connectPresence - отмечает соединение клиента в сети и продлевает его, пока клиент подключён.
+// Если пользователь только что появился в сети, об этом узнают собеседники во всех его
+// активных чатах. Возвращает функцию, которую нужно вызвать при отключении.
+func (h *ChatHandler) connectPresence(client *hub.Client) (disconnect func()) {
+	connID := uuid.NewString()
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	cameOnline, err := h.presence.Connect(ctx, client.UserID, connID)
+	cancel()
+	if err != nil {
+		log.Println("❌", err)
+	} else if cameOnline {
+		h.publishPresence(client.UserID, models.PresencePayload{UserID: client.UserID, Online: true})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(h.presence.HeartbeatInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+				if err := h.presence.Heartbeat(ctx, client.UserID, connID); err != nil {
+					log.Println("❌", err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+		defer cancel()
+		wentOffline, lastSeen, err := h.presence.Disconnect(ctx, client.UserID, connID)
+		if err != nil {
+			log.Println("❌", err)
+			return
+		}
+		if wentOffline {
+			h.publishOffline(ctx, client.UserID, lastSeen)
+		}
+	}
+}
+
+// publishOffline - сообщает собеседникам, что пользователь вышел из сети; время визита
+// не раскрывается, если пользователь его скрыл
+func (h *ChatHandler) publishOffline(ctx context.Context, userID int64, lastSeen time.Time) {
+	offline := models.PresencePayload{UserID: userID}
+	hidden, err := h.chatRepo.HiddenLastSeen(ctx, []int64{userID})
+	if err != nil {
+		log.Println("❌", err)
+	} else if !hidden[userID] {
+		offline.LastSeen = &lastSeen
+	}
+	h.publishPresence(userID, offline)
+}
+
+// RunPresenceSweeper - раз в interval отмечает ушедшими пользователей, чьи соединения
+// истекли без закрытия (например, упала реплика), и рассылает их собеседникам presence;
+// работает, пока не отменён ctx
+func (h *ChatHandler) RunPresenceSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sweepCtx, cancel := context.WithTimeout(ctx, presenceTimeout)
+		expired, err := h.presence.Sweep(sweepCtx)
+		cancel()
+		if err != nil {
+			log.Println("❌", err)
+		}
+		for _, e := range expired {
+			log.Printf("👻 Соединения пользователя %d истекли без закрытия, он вне сети", e.UserID)
+			userCtx, cancel := context.WithTimeout(ctx, presenceTimeout)
+			h.publishOffline(userCtx, e.UserID, e.LastSeen)
+			cancel()
+		}
+	}
+}
+
+// publishPresence - рассылает кадр presence во все активные чаты пользователя
+func (h *ChatHandler) publishPresence(userID int64, payload models.PresencePayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	chatIDs, err := h.chatRepo.ActiveChatIDs(ctx, userID)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	for _, chatID := range chatIDs {
+		h.publish(chatID, protocol.TypePresence, payload)
+	}
+}
+
+// partnerPresence - присутствие собеседников пользователя в чате; время визита скрывших
+// его собеседников не раскрывается
+func (h *ChatHandler) partnerPresence(ctx context.Context, chat *models.Chat, userID int64) ([]models.PresencePayload, error) {
+	partners := make([]int64, 0, len(chat.Members))
+	for _, id := range chat.MemberIDs() {
+		if id != userID {
+			partners = append(partners, id)
+		}
+	}
+	presences, err := h.presence.Get(ctx, partners)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := h.chatRepo.HiddenLastSeen(ctx, partners)
+	if err != nil {
+		return nil, err
+	}
+	for i := range presences {
+		if hidden[presences[i].UserID] {
+			presences[i].LastSeen = nil
+		}
+	}
+	return presences, nil
+}
+
+// sendPartnerPresence - при подключении сообщает клиенту, кто из собеседников сейчас в сети
+func (h *ChatHandler) sendPartnerPresence(client *hub.Client, chat *models.Chat) {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	presences, err := h.partnerPresence(ctx, chat, client.UserID)
+	if err != nil {
+		log.Println("❌", err)
+		return
+	}
+	for _, p := range presences {
+		h.sendFrame(client, protocol.TypePresence, "", p)
+	}
+}
+
+// GetPresence - присутствие собеседников в чате; доступно только участникам чата
+func (h *ChatHandler) GetPresence(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+	chat, ok := h.memberChat(c, userID)
+	if !ok {
+		return nil
+	}
+
+	presences, err := h.partnerPresence(c.Context(), chat, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(presences)
+}
+
+// GetSettings - настройки пользователя в чатах
+func (h *ChatHandler) GetSettings(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+
+	settings, err := h.chatRepo.GetUserSettings(c.Context(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(settings)
+}
+
+// UpdateSettings - сохраняет настройки пользователя; пока это только скрытие времени визита
+func (h *ChatHandler) UpdateSettings(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		HideLastSeen *bool `json:"hide_last_seen"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.HideLastSeen == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+
+	settings := models.UserSettings{UserID: userID, HideLastSeen: *req.HideLastSeen}
+	if err := h.chatRepo.SaveUserSettings(c.Context(), &settings); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(settings)
+}
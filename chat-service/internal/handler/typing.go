@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"chat-service/internal/hub"
+	"chat-service/pkg/models"
+	"chat-service/pkg/protocol"
+)
+
+const (
+	// typingThrottle - не чаще этого соединение может начинать набор; пока пользователь
+	// печатает, клиент повторяет typing start с таким интервалом
+	typingThrottle = 2 * time.Second
+	// typingTTL - если за это время после последнего start не пришёл stop,
+	// сервер сам рассылает stop (клиент закрыл вкладку, пропала сеть)
+	typingTTL = 6 * time.Second
+)
+
+// typingState - индикатор набора текста одного соединения. Кадры typing не сохраняются
+// и рассылаются только собеседникам; лишние кадры клиента отбрасываются.
+type typingState struct {
+	mu        sync.Mutex
+	typing    bool
+	lastStart time.Time
+	deadline  time.Time
+	expiry    *time.Timer
+	onExpire  func() // рассылает stop, когда индикатор истёк
+}
+
+func newTypingState(onExpire func()) *typingState {
+	return &typingState{onExpire: onExpire}
+}
+
+// start - пользователь печатает. Возвращает false, если кадр нужно отбросить:
+// соединение уже начинало набор меньше typingThrottle назад. Отброшенный start всё равно
+// продлевает показанный индикатор - пользователь ещё печатает.
+func (t *typingState) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastStart) < typingThrottle {
+		if t.typing {
+			t.extend(now)
+		}
+		return false
+	}
+	t.typing, t.lastStart = true, now
+	t.extend(now)
+	return true
+}
+
+// extend - индикатор истечёт через typingTTL от now; вызывается под t.mu
+func (t *typingState) extend(now time.Time) {
+	t.deadline = now.Add(typingTTL)
+	if t.expiry == nil {
+		t.expiry = time.AfterFunc(typingTTL, t.expire)
+	} else {
+		t.expiry.Reset(typingTTL)
+	}
+}
+
+// stop - пользователь перестал печатать. Возвращает false, если индикатор и так не показан.
+func (t *typingState) stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.typing {
+		return false
+	}
+	t.typing = false
+	t.expiry.Stop()
+	return true
+}
+
+// expire - срабатывает по таймеру; ничего не делает, если за это время пришёл новый start
+// (таймер мог сработать одновременно с Reset) или stop
+func (t *typingState) expire() {
+	t.mu.Lock()
+	expired := t.typing && !time.Now().Before(t.deadline)
+	if expired {
+		t.typing = false
+	}
+	t.mu.Unlock()
+
+	if expired {
+		t.onExpire()
+	}
+}
+
+// handleTyping - кадр typing от клиента: рассылает его собеседникам, если он проходит ограничение
+func (h *ChatHandler) handleTyping(client *hub.Client, state *typingState, env *protocol.Envelope) {
+	var payload models.TypingPayload
+	if err := env.DecodePayload(&payload); err != nil {
+		h.hub.SendTo(client, protocol.EncodeError(env.ID, protocol.ErrInvalidPayload, err.Error()))
+		return
+	}
+
+	changed := state.stop
+	if payload.Typing {
+		changed = state.start
+	}
+	if changed() {
+		h.publishTyping(client, payload.Typing)
+	}
+}
+
+// publishTyping - рассылает состояние индикатора пользователя в чат; отправителю кадр
+// не доставляется (см. isOwnEvent)
+func (h *ChatHandler) publishTyping(client *hub.Client, typing bool) {
+	h.publish(client.ChatID(), protocol.TypeTyping, models.TypingPayload{Typing: typing, UserID: client.UserID})
+}
+
+// isOwnEvent - кадр typing или presence о самом клиенте: такие события показываются
+// только собеседникам
+func isOwnEvent(client *hub.Client, frame []byte) bool {
+	var env struct {
+		Payload struct {
+			UserID int64 `json:"user_id"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(frame, &env); err != nil {
+		log.Println("❌ Ошибка разбора кадра:", err)
+		return false
+	}
+	return env.Payload.UserID == client.UserID
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottledStartExtendsIndicator(t *testing.T) {
+	state := newTypingState(func() {})
+	if !state.start() {
+		t.Fatal("первый start должен рассылаться")
+	}
+	first := state.deadline
+
+	time.Sleep(10 * time.Millisecond)
+	if state.start() {
+		t.Fatal("повторный start в пределах typingThrottle должен отбрасываться")
+	}
+	if !state.deadline.After(first) {
+		t.Fatalf("отброшенный start не продлил индикатор: %v, было %v", state.deadline, first)
+	}
+
+	if !state.stop() {
+		t.Fatal("stop должен рассылаться, пока индикатор показан")
+	}
+	// После stop отброшенный start не включает индикатор заново
+	if state.start() || state.typing {
+		t.Fatal("start в пределах typingThrottle после stop должен отбрасываться")
+	}
+}
+
+func TestIndicatorExpires(t *testing.T) {
+	expired := make(chan struct{})
+	state := newTypingState(func() { close(expired) })
+	state.start()
+
+	// Истечение проверяется по deadline: таймер, сработавший до него, ничего не делает
+	state.mu.Lock()
+	state.deadline = time.Now()
+	state.mu.Unlock()
+	state.expire()
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("индикатор не истёк")
+	}
+	if state.stop() {
+		t.Fatal("после истечения индикатор уже снят")
+	}
+}
@@ -0,0 +1,186 @@
+// Package hub - рассылка событий WebSocket-клиентам комнат чатов.
+//
+// У каждой комнаты свой мьютекс, у каждого клиента - буферизованная очередь отправки,
+// которую разбирает отдельная горутина записи (writePump обработчика). Клиент, не
+// успевающий забирать сообщения, отключается, а опустевшие комнаты удаляются.
+package hub
+
+import (
+	"log"
+	"sync"
+)
+
+// Client - подключение пользователя к комнате чата
+type Client struct {
+	UserID int64
+	chatID int64
+	send   chan []byte
+	closed bool // очередь закрыта; защищено мьютексом комнаты
+}
+
+// ChatID - внутренний ID чата, к комнате которого подключён клиент
+func (c *Client) ChatID() int64 {
+	return c.chatID
+}
+
+// Send - очередь сообщений клиента. Закрывается, когда клиент покинул комнату или был отключён.
+func (c *Client) Send() <-chan []byte {
+	return c.send
+}
+
+// room - участники одного чата
+type room struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+	removed bool // комната удалена из хаба; защищено мьютексом комнаты
+}
+
+// Hub - комнаты чатов с подключёнными клиентами
+type Hub struct {
+	mu         sync.Mutex // защищает rooms; берётся раньше мьютекса комнаты
+	rooms      map[int64]*room
+	sendBuffer int
+	// onOpen и onClose вызываются под mu, когда комната чата появляется и удаляется
+	onOpen, onClose func(chatID int64)
+}
+
+// NewHub - конструктор; sendBuffer - сколько сообщений может ждать отправки одному клиенту
+func NewHub(sendBuffer int) *Hub {
+	return &Hub{
+		rooms:      make(map[int64]*room),
+		sendBuffer: sendBuffer,
+	}
+}
+
+// SetRoomHooks - onOpen вызывается при появлении комнаты чата (первый клиент), onClose -
+// при её удалении (ушёл последний). Хуки вызываются под мьютексом хаба в порядке событий,
+// поэтому не должны блокироваться и обращаться к хабу. Задаются до первого Join.
+func (h *Hub) SetRoomHooks(onOpen, onClose func(chatID int64)) {
+	h.onOpen = onOpen
+	h.onClose = onClose
+}
+
+// Join - подключает клиента к комнате чата, создавая её при необходимости
+func (h *Hub) Join(chatID, userID int64) *Client {
+	client := &Client{UserID: userID, chatID: chatID, send: make(chan []byte, h.sendBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[chatID]
+	if !ok {
+		r = &room{clients: make(map[*Client]struct{})}
+		h.rooms[chatID] = r
+		if h.onOpen != nil {
+			h.onOpen(chatID)
+		}
+	}
+	r.mu.Lock()
+	r.clients[client] = struct{}{}
+	r.mu.Unlock()
+	return client
+}
+
+// Leave - отключает клиента от комнаты и закрывает его очередь. Пустая комната удаляется
+// под обоими мьютексами и помечается удалённой, чтобы рассылка, успевшая её взять,
+// перешла в новую комнату чата. Повторный вызов и вызов для уже отключённого клиента безопасны.
+func (h *Hub) Leave(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[client.chatID]
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(client)
+	if len(r.clients) == 0 {
+		r.removed = true
+		delete(h.rooms, client.chatID)
+		if h.onClose != nil {
+			h.onClose(client.chatID)
+		}
+	}
+}
+
+// lockRoom - комната чата под её мьютексом; false, если комнаты нет. Между поиском комнаты
+// и захватом её мьютекса Leave может удалить её, а Join - создать новую: тогда поиск повторяется.
+func (h *Hub) lockRoom(chatID int64) (*room, bool) {
+	for {
+		h.mu.Lock()
+		r, ok := h.rooms[chatID]
+		h.mu.Unlock()
+		if !ok {
+			return nil, false
+		}
+		r.mu.Lock()
+		if !r.removed {
+			return r, true
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Broadcast - ставит payload в очередь всем клиентам комнаты. Клиент с переполненной
+// очередью отключается: его очередь закрывается, и обработчик завершает соединение.
+func (h *Hub) Broadcast(chatID int64, payload []byte) {
+	r, ok := h.lockRoom(chatID)
+	if !ok {
+		return
+	}
+	defer r.mu.Unlock()
+	for client := range r.clients {
+		r.deliver(client, payload)
+	}
+}
+
+// SendTo - ставит payload в очередь одному клиенту (например, ответ на его запрос).
+// Клиент с переполненной очередью отключается, как и при рассылке.
+func (h *Hub) SendTo(client *Client, payload []byte) {
+	r, ok := h.lockRoom(client.chatID)
+	if !ok {
+		return
+	}
+	defer r.mu.Unlock()
+	r.deliver(client, payload)
+}
+
+// Rooms - число комнат с подключёнными клиентами
+func (h *Hub) Rooms() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.rooms)
+}
+
+// Clients - число клиентов в комнате чата
+func (h *Hub) Clients(chatID int64) int {
+	r, ok := h.lockRoom(chatID)
+	if !ok {
+		return 0
+	}
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
+
+// deliver - ставит payload в очередь клиента без ожидания; при переполнении очереди
+// отключает клиента. Вызывается под r.mu.
+func (r *room) deliver(client *Client, payload []byte) {
+	if client.closed {
+		return
+	}
+	select {
+	case client.send <- payload:
+	default:
+		log.Printf("🐢 Клиент пользователя %d не успевает получать сообщения чата %d, отключаем", client.UserID, client.chatID)
+		r.remove(client)
+	}
+}
+
+// remove - убирает клиента из комнаты и закрывает его очередь; вызывается под r.mu
+func (r *room) remove(client *Client) {
+	if client.closed {
+		return
+	}
+	delete(r.clients, client)
+	close(client.send)
+	client.closed = true
+}
@@ -0,0 +1,162 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendBuffer - размер очереди клиента, как у обработчика WebSocket
+const sendBuffer = 64
+
+// drain - забирает сообщения клиента, пока его очередь не закроется; возвращает их число
+func drain(client *Client) <-chan int {
+	n := make(chan int, 1)
+	go func() {
+		count := 0
+		for range client.Send() {
+			count++
+		}
+		n <- count
+	}()
+	return n
+}
+
+func TestConcurrentJoinLeaveBroadcast(t *testing.T) {
+	h := NewHub(sendBuffer)
+	const chats, clientsPerChat, rounds = 4, 8, 50
+
+	var wg sync.WaitGroup
+	for chatID := int64(1); chatID <= chats; chatID++ {
+		for i := 0; i < clientsPerChat; i++ {
+			wg.Add(1)
+			go func(chatID int64, userID int64) {
+				defer wg.Done()
+				for round := 0; round < rounds; round++ {
+					client := h.Join(chatID, userID)
+					done := drain(client)
+					h.SendTo(client, []byte("hello"))
+					h.Leave(client)
+					<-done
+				}
+			}(chatID, int64(i))
+		}
+		wg.Add(1)
+		go func(chatID int64) {
+			defer wg.Done()
+			for round := 0; round < rounds*clientsPerChat; round++ {
+				h.Broadcast(chatID, []byte(fmt.Sprintf("message %d", round)))
+			}
+		}(chatID)
+	}
+	wg.Wait()
+
+	if rooms := h.Rooms(); rooms != 0 {
+		t.Fatalf("после выхода всех клиентов осталось %d комнат", rooms)
+	}
+}
+
+// Рассылка, взявшая комнату до её удаления, доходит до участника, вошедшего в новую комнату чата
+func TestBroadcastReachesRecreatedRoom(t *testing.T) {
+	h := NewHub(sendBuffer)
+	old := h.Join(1, 1)
+	r := h.rooms[1]
+
+	// Пока мьютекс старой комнаты занят, рассылка успевает найти её и ждёт
+	r.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		h.Broadcast(1, []byte("after rejoin"))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// Тем временем последний участник выходит (как в Leave), и в чат входит новый
+	h.mu.Lock()
+	r.remove(old)
+	r.removed = true
+	delete(h.rooms, 1)
+	h.mu.Unlock()
+	fresh := h.Join(1, 2)
+	r.mu.Unlock()
+	<-done
+
+	select {
+	case payload := <-fresh.Send():
+		if string(payload) != "after rejoin" {
+			t.Fatalf("неожиданное сообщение %q", payload)
+		}
+	default:
+		t.Fatal("новый участник не получил рассылку")
+	}
+}
+
+func TestSlowConsumerEvicted(t *testing.T) {
+	h := NewHub(sendBuffer)
+	slow := h.Join(1, 1)
+	fast := h.Join(1, 2)
+
+	for i := 0; i <= sendBuffer; i++ {
+		h.Broadcast(1, []byte("message"))
+		// Быстрый клиент забирает каждое сообщение сразу
+		if _, ok := <-fast.Send(); !ok {
+			t.Fatal("быстрый клиент отключён")
+		}
+	}
+
+	// Медленный клиент отключён: в очереди остались sendBuffer сообщений, затем она закрыта
+	count := 0
+	for range slow.Send() {
+		count++
+	}
+	if count != sendBuffer {
+		t.Fatalf("медленный клиент получил %d сообщений, ожидалось %d", count, sendBuffer)
+	}
+	if clients := h.Clients(1); clients != 1 {
+		t.Fatalf("в комнате %d клиентов, ожидался 1", clients)
+	}
+
+	// Повторный выход отключённого клиента безопасен и не трогает остальных
+	h.Leave(slow)
+	if clients := h.Clients(1); clients != 1 {
+		t.Fatalf("после выхода отключённого клиента в комнате %d клиентов", clients)
+	}
+	h.Leave(fast)
+	if rooms := h.Rooms(); rooms != 0 {
+		t.Fatalf("осталось %d комнат", rooms)
+	}
+}
+
+func TestEmptyRoomRemoved(t *testing.T) {
+	h := NewHub(sendBuffer)
+	a := h.Join(1, 1)
+	b := h.Join(1, 2)
+	c := h.Join(2, 3)
+	if rooms := h.Rooms(); rooms != 2 {
+		t.Fatalf("ожидалось 2 комнаты, получено %d", rooms)
+	}
+
+	h.Leave(a)
+	if clients := h.Clients(1); clients != 1 {
+		t.Fatalf("в комнате 1 осталось %d клиентов, ожидался 1", clients)
+	}
+	h.Leave(b)
+	h.Leave(b)
+	if clients := h.Clients(1); clients != 0 {
+		t.Fatalf("в комнате 1 осталось %d клиентов", clients)
+	}
+	if rooms := h.Rooms(); rooms != 1 {
+		t.Fatalf("пустая комната не удалена: комнат %d", rooms)
+	}
+
+	// Рассылка в удалённую комнату ничего не делает
+	h.Broadcast(1, []byte("nobody"))
+	h.Leave(c)
+	if rooms := h.Rooms(); rooms != 0 {
+		t.Fatalf("осталось %d комнат", rooms)
+	}
+	if _, ok := <-a.Send(); ok {
+		t.Fatal("очередь вышедшего клиента не закрыта")
+	}
+}
@@ -0,0 +1,71 @@
+// Package lifecycle - события жизненного цикла чатов для других сервисов. События
+// публикуются в Redis pub/sub; по ним matchmaking-service учитывает чаты в репутации.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"chat-service/pkg/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// endedChannel - канал событий о завершении чатов (JSON ChatEnded)
+const endedChannel = "chat:ended"
+
+// ReasonArchived - причина завершения заброшенного чата, архивированного по сроку
+const ReasonArchived = "archived"
+
+// ChatEnded - чат завершён: участником (пропуск, выход) или архивацией заброшенного чата
+type ChatEnded struct {
+	ChatID    int64     `json:"chat_id"`
+	MemberIDs []int64   `json:"member_ids"`
+	CreatedAt time.Time `json:"created_at"`
+	// EndedAt - когда чат завершён; для заброшенного чата - время последней активности в нём
+	EndedAt time.Time `json:"ended_at"`
+	// EndedBy - кто завершил чат; 0, если чат заброшен
+	EndedBy int64  `json:"ended_by,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// EndedByMember - событие о чате, который только что завершил участник
+func EndedByMember(chat *models.Chat) ChatEnded {
+	event := ChatEnded{
+		ChatID:    chat.ID,
+		MemberIDs: chat.MemberIDs(),
+		CreatedAt: chat.CreatedAt,
+		Reason:    chat.EndReason,
+	}
+	if chat.EndedAt != nil {
+		event.EndedAt = *chat.EndedAt
+	}
+	if chat.EndedBy != nil {
+		event.EndedBy = *chat.EndedBy
+	}
+	return event
+}
+
+// Publisher - публикация событий жизненного цикла чатов
+type Publisher struct {
+	client *redis.Client
+}
+
+// NewPublisher - конструктор
+func NewPublisher(client *redis.Client) *Publisher {
+	return &Publisher{client: client}
+}
+
+// ChatEnded - сообщает другим сервисам о завершении чата
+func (p *Publisher) ChatEnded(ctx context.Context, event ChatEnded) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования события о завершении чата %d: %w", event.ChatID, err)
+	}
+	if err := p.client.Publish(ctx, endedChannel, payload).Err(); err != nil {
+		return fmt.Errorf("ошибка публикации события о завершении чата %d: %w", event.ChatID, err)
+	}
+	return nil
+}
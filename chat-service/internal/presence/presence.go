@@ -0,0 +1,217 @@
+// Package presence - кто из пользователей сейчас в сети. Пользователь в сети, пока у него
+// есть хотя бы одно WebSocket-соединение с чатом на любой реплике.
+//
+// Соединения пользователя хранятся в Redis в ZSET presence:conns:<user_id> со временем
+// истечения в качестве score. Пока соединение открыто, реплика продлевает его heartbeat'ом;
+// соединения упавшей реплики истекают сами через TTL. Пользователи в сети дополнительно
+// хранятся в ZSET presence:online со временем истечения последнего соединения: по нему
+// Sweep находит тех, чьи соединения истекли без Disconnect.
+package presence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"chat-service/pkg/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	connsPrefix    = "presence:conns:"
+	lastSeenPrefix = "presence:last_seen:"
+	onlineKey      = "presence:online"
+	// sweepBatch - сколько пользователей Sweep проверяет за один вызов скрипта
+	sweepBatch = 100
+	// lastSeenTTL - сколько хранить время последнего визита пользователя
+	lastSeenTTL = 30 * 24 * time.Hour
+)
+
+// Tracker - учёт соединений пользователей в Redis
+type Tracker struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewTracker - конструктор; соединение без heartbeat дольше ttl считается закрытым
+func NewTracker(client *redis.Client, ttl time.Duration) *Tracker {
+	return &Tracker{client: client, ttl: ttl}
+}
+
+// HeartbeatInterval - как часто продлевать соединение, чтобы оно не истекло между продлениями
+func (t *Tracker) HeartbeatInterval() time.Duration {
+	return t.ttl / 3
+}
+
+func connsKey(userID int64) string {
+	return connsPrefix + strconv.FormatInt(userID, 10)
+}
+
+func lastSeenKey(userID int64) string {
+	return lastSeenPrefix + strconv.FormatInt(userID, 10)
+}
+
+// Lua-скрипт регистрации соединения. Возвращает 1, если до него у пользователя
+// не было живых соединений.
+const connectScript = `
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+local was_online = redis.call('ZCARD', key) > 0
+redis.call('ZADD', key, now + ttl, ARGV[3])
+redis.call('PEXPIRE', key, ttl)
+redis.call('ZADD', KEYS[2], now + ttl, ARGV[4])
+if was_online then
+    return 0
+end
+return 1
+`
+
+// Lua-скрипт закрытия соединения. Возвращает 1, если живых соединений не осталось;
+// тогда же запоминается время последнего визита и пользователь убирается из presence:online.
+const disconnectScript = `
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+redis.call('ZREM', key, ARGV[2])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+if redis.call('ZCARD', key) > 0 then
+    return 0
+end
+redis.call('SET', KEYS[2], now, 'PX', ARGV[3])
+redis.call('ZREM', KEYS[3], ARGV[4])
+return 1
+`
+
+// Lua-скрипт поиска пользователей, у которых все соединения истекли (реплика упала, не
+// вызвав Disconnect). Такие пользователи убираются из presence:online, поэтому каждого
+// возвращает только одна реплика. Возвращает пары {user_id, last_seen в мс}.
+const sweepScript = `
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, tonumber(ARGV[3]))
+local result = {}
+for _, uid in ipairs(expired) do
+    local conns = ARGV[4] .. uid
+    redis.call('ZREMRANGEBYSCORE', conns, '-inf', now)
+    local live = redis.call('ZRANGE', conns, -1, -1, 'WITHSCORES')
+    if #live > 0 then
+        -- Соединение продлили после выборки: переносим срок пользователя
+        redis.call('ZADD', KEYS[1], live[2], uid)
+    else
+        local seen_key = ARGV[5] .. uid
+        local seen = redis.call('GET', seen_key)
+        if not seen then
+            -- Heartbeat'а не было: последний раз пользователь был в сети при подключении
+            seen = tostring(tonumber(redis.call('ZSCORE', KEYS[1], uid)) - ttl)
+            redis.call('SET', seen_key, seen, 'PX', ARGV[6])
+        end
+        redis.call('ZREM', KEYS[1], uid)
+        table.insert(result, uid)
+        table.insert(result, seen)
+    end
+end
+return result
+`
+
+// Connect - регистрирует соединение connID. Возвращает true, если пользователь только что
+// появился в сети.
+func (t *Tracker) Connect(ctx context.Context, userID int64, connID string) (bool, error) {
+	now := time.Now().UnixMilli()
+	cameOnline, err := t.client.Eval(ctx, connectScript, []string{connsKey(userID), onlineKey},
+		now, t.ttl.Milliseconds(), connID, userID).Int()
+	if err != nil {
+		return false, fmt.Errorf("ошибка регистрации соединения пользователя %d: %w", userID, err)
+	}
+	return cameOnline == 1, nil
+}
+
+// Heartbeat - продлевает соединение. Время последнего визита тоже обновляется, чтобы
+// оно было известно, даже если реплика упадёт, не закрыв соединение.
+func (t *Tracker) Heartbeat(ctx context.Context, userID int64, connID string) error {
+	now := time.Now()
+	_, err := t.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, connsKey(userID), redis.Z{Score: float64(now.Add(t.ttl).UnixMilli()), Member: connID})
+		pipe.PExpire(ctx, connsKey(userID), t.ttl)
+		pipe.ZAdd(ctx, onlineKey, redis.Z{Score: float64(now.Add(t.ttl).UnixMilli()), Member: userID})
+		pipe.Set(ctx, lastSeenKey(userID), now.UnixMilli(), lastSeenTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка продления соединения пользователя %d: %w", userID, err)
+	}
+	return nil
+}
+
+// Disconnect - закрывает соединение. Возвращает true и время ухода, если у пользователя
+// не осталось соединений.
+func (t *Tracker) Disconnect(ctx context.Context, userID int64, connID string) (bool, time.Time, error) {
+	now := time.Now()
+	wentOffline, err := t.client.Eval(ctx, disconnectScript, []string{connsKey(userID), lastSeenKey(userID), onlineKey},
+		now.UnixMilli(), connID, lastSeenTTL.Milliseconds(), userID).Int()
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("ошибка закрытия соединения пользователя %d: %w", userID, err)
+	}
+	return wentOffline == 1, now.UTC().Truncate(time.Millisecond), nil
+}
+
+// Get - присутствие пользователей; время последнего визита заполняется только для тех,
+// кто не в сети
+func (t *Tracker) Get(ctx context.Context, userIDs []int64) ([]models.PresencePayload, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	counts := make([]*redis.IntCmd, len(userIDs))
+	lastSeen := make([]*redis.StringCmd, len(userIDs))
+	_, err := t.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, userID := range userIDs {
+			counts[i] = pipe.ZCount(ctx, connsKey(userID), "("+now, "+inf")
+			lastSeen[i] = pipe.Get(ctx, lastSeenKey(userID))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("ошибка загрузки присутствия пользователей: %w", err)
+	}
+
+	result := make([]models.PresencePayload, 0, len(userIDs))
+	for i, userID := range userIDs {
+		p := models.PresencePayload{UserID: userID, Online: counts[i].Val() > 0}
+		if ms, err := lastSeen[i].Int64(); !p.Online && err == nil {
+			seen := time.UnixMilli(ms).UTC()
+			p.LastSeen = &seen
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// Expired - пользователь, все соединения которого истекли без Disconnect
+type Expired struct {
+	UserID   int64
+	LastSeen time.Time
+}
+
+// Sweep - находит пользователей, у которых все соединения истекли (реплика упала, не закрыв
+// их), и отмечает их ушедшими. Каждый такой пользователь возвращается ровно одной реплике.
+func (t *Tracker) Sweep(ctx context.Context) ([]Expired, error) {
+	var result []Expired
+	for {
+		raw, err := t.client.Eval(ctx, sweepScript, []string{onlineKey},
+			time.Now().UnixMilli(), t.ttl.Milliseconds(), sweepBatch, connsPrefix, lastSeenPrefix, lastSeenTTL.Milliseconds()).StringSlice()
+		if err != nil {
+			return result, fmt.Errorf("ошибка поиска истёкших соединений: %w", err)
+		}
+		for i := 0; i+1 < len(raw); i += 2 {
+			userID, err := strconv.ParseInt(raw[i], 10, 64)
+			if err != nil {
+				continue
+			}
+			ms, _ := strconv.ParseInt(raw[i+1], 10, 64)
+			result = append(result, Expired{UserID: userID, LastSeen: time.UnixMilli(ms).UTC()})
+		}
+		if len(raw) < 2*sweepBatch {
+			return result, nil
+		}
+	}
+}
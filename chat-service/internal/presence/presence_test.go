@@ -0,0 +1,94 @@
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testTTL - короткий срок соединения, чтобы тесты дожидались его истечения
+const testTTL = 50 * time.Millisecond
+
+func newTestTracker(t *testing.T) *Tracker {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewTracker(client, testTTL)
+}
+
+func TestSweepReportsExpiredConnections(t *testing.T) {
+	ctx := context.Background()
+	tracker := newTestTracker(t)
+
+	if _, err := tracker.Connect(ctx, 1, "crashed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracker.Connect(ctx, 2, "alive"); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := tracker.Sweep(ctx); err != nil || len(expired) != 0 {
+		t.Fatalf("до истечения соединений никто не должен уйти: %v, %v", expired, err)
+	}
+
+	// Реплика пользователя 1 упала: его соединение больше не продлевается
+	time.Sleep(testTTL / 2)
+	if err := tracker.Heartbeat(ctx, 2, "alive"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(testTTL/2 + 10*time.Millisecond)
+
+	expired, err := tracker.Sweep(ctx)
+	if err != nil || len(expired) != 1 || expired[0].UserID != 1 || expired[0].LastSeen.IsZero() {
+		t.Fatalf("ожидался уход только пользователя 1: %v, %v", expired, err)
+	}
+	presences, err := tracker.Get(ctx, []int64{1, 2})
+	if err != nil || presences[0].Online || presences[0].LastSeen == nil || !presences[1].Online {
+		t.Fatalf("неожиданное присутствие: %+v, %v", presences, err)
+	}
+
+	// Об уходе сообщается один раз
+	if expired, err := tracker.Sweep(ctx); err != nil || len(expired) != 0 {
+		t.Fatalf("повторная проверка вернула %v, %v", expired, err)
+	}
+}
+
+func TestSweepSkipsClosedConnections(t *testing.T) {
+	ctx := context.Background()
+	tracker := newTestTracker(t)
+
+	if _, err := tracker.Connect(ctx, 1, "conn"); err != nil {
+		t.Fatal(err)
+	}
+	wentOffline, _, err := tracker.Disconnect(ctx, 1, "conn")
+	if err != nil || !wentOffline {
+		t.Fatalf("пользователь должен уйти при закрытии соединения: %v, %v", wentOffline, err)
+	}
+
+	// Об уходе уже сообщил Disconnect
+	time.Sleep(testTTL + 10*time.Millisecond)
+	if expired, err := tracker.Sweep(ctx); err != nil || len(expired) != 0 {
+		t.Fatalf("закрытое соединение не должно попасть в проверку: %v, %v", expired, err)
+	}
+}
+
+func TestSweepKeepsReconnectedUser(t *testing.T) {
+	ctx := context.Background()
+	tracker := newTestTracker(t)
+
+	if _, err := tracker.Connect(ctx, 1, "crashed"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(testTTL + 10*time.Millisecond)
+
+	// Пользователь переподключился к другой реплике раньше проверки
+	cameOnline, err := tracker.Connect(ctx, 1, "fresh")
+	if err != nil || !cameOnline {
+		t.Fatalf("переподключение: %v, %v", cameOnline, err)
+	}
+	if expired, err := tracker.Sweep(ctx); err != nil || len(expired) != 0 {
+		t.Fatalf("переподключившийся пользователь не должен уйти: %v, %v", expired, err)
+	}
+}
@@ -0,0 +1,215 @@
+// Package ratelimit - ограничение частоты сообщений чата, общее для всех реплик.
+//
+// Каждому пользователю и каждому чату выделено по ведру токенов в Redis: сообщение
+// забирает токен из обоих, токены восстанавливаются с постоянной скоростью. Кроме того,
+// один и тот же достаточно длинный текст от пользователя в чате не принимается повторно
+// в течение окна: короткие ответы вроде «ок» повторяются естественно.
+// Настройки хранятся в хэше ratelimit:config и меняются без перезапуска.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	configKey        = "ratelimit:config"
+	userBucketPrefix = "ratelimit:user:"
+	chatBucketPrefix = "ratelimit:chat:"
+	duplicatePrefix  = "ratelimit:dup:"
+)
+
+// Причины отказа
+const (
+	ReasonUser      = "user"      // пользователь пишет слишком часто
+	ReasonChat      = "chat"      // в чат пишут слишком часто
+	ReasonDuplicate = "duplicate" // тот же текст уже отправлен недавно
+)
+
+// Limits - настройки ограничений. Rate - сколько сообщений в секунду восстанавливается,
+// Burst - сколько можно отправить подряд.
+type Limits struct {
+	UserRate  float64 `json:"user_rate"`
+	UserBurst int     `json:"user_burst"`
+	ChatRate  float64 `json:"chat_rate"`
+	ChatBurst int     `json:"chat_burst"`
+	// DuplicateWindowSeconds - сколько секунд не принимать тот же текст повторно; 0 - не проверять
+	DuplicateWindowSeconds int `json:"duplicate_window_seconds"`
+	// DuplicateMinLength - с какой длины (в символах) текст проверяется на повтор
+	DuplicateMinLength int `json:"duplicate_min_length"`
+}
+
+// Validate - проверяет, что с такими настройками можно отправлять сообщения
+func (l Limits) Validate() error {
+	if l.UserRate <= 0 || l.ChatRate <= 0 {
+		return errors.New("user_rate и chat_rate должны быть больше 0")
+	}
+	if l.UserBurst < 1 || l.ChatBurst < 1 {
+		return errors.New("user_burst и chat_burst должны быть не меньше 1")
+	}
+	if l.DuplicateWindowSeconds < 0 || l.DuplicateMinLength < 0 {
+		return errors.New("duplicate_window_seconds и duplicate_min_length не могут быть отрицательными")
+	}
+	return nil
+}
+
+// Decision - результат проверки сообщения
+type Decision struct {
+	Allowed    bool
+	Reason     string        // почему сообщение отклонено
+	RetryAfter time.Duration // через сколько можно повторить
+}
+
+// Limiter - ограничитель частоты сообщений в Redis
+type Limiter struct {
+	client   *redis.Client
+	defaults Limits
+}
+
+// NewLimiter - конструктор; defaults действуют, пока настройки не изменены через SetLimits
+func NewLimiter(client *redis.Client, defaults Limits) *Limiter {
+	return &Limiter{client: client, defaults: defaults}
+}
+
+// Lua-скрипт проверки сообщения. Сначала ищет недавний дубль текста не короче
+// duplicate_min_length (повтор с тем же client_id дублем не считается - это переотправка
+// после обрыва связи), затем проверяет оба ведра и, только если токены есть в обоих,
+// забирает по одному.
+// Возвращает {1, "", 0} или {0, причина, через сколько мс повторить}.
+const allowScript = `
+local now = tonumber(ARGV[1])
+local client_id = ARGV[7]
+local cfg = redis.call('HMGET', KEYS[1], 'user_rate', 'user_burst', 'chat_rate', 'chat_burst', 'duplicate_window_seconds', 'duplicate_min_length')
+local user_rate = tonumber(cfg[1]) or tonumber(ARGV[2])
+local user_burst = tonumber(cfg[2]) or tonumber(ARGV[3])
+local chat_rate = tonumber(cfg[3]) or tonumber(ARGV[4])
+local chat_burst = tonumber(cfg[4]) or tonumber(ARGV[5])
+local dup_window = tonumber(cfg[5]) or tonumber(ARGV[6])
+local dup_min = tonumber(cfg[6]) or tonumber(ARGV[9])
+local check_dup = dup_window > 0 and tonumber(ARGV[8]) >= dup_min
+
+if check_dup then
+    local prev = redis.call('GET', KEYS[4])
+    if prev and (client_id == '' or prev ~= client_id) then
+        return {0, 'duplicate', redis.call('PTTL', KEYS[4])}
+    end
+end
+
+local function tokens(key, rate, burst)
+    local state = redis.call('HMGET', key, 'tokens', 'ts')
+    local t = tonumber(state[1]) or burst
+    local ts = tonumber(state[2]) or now
+    return math.min(burst, t + math.max(0, now - ts) * rate / 1000)
+end
+
+local user_tokens = tokens(KEYS[2], user_rate, user_burst)
+if user_tokens < 1 then
+    return {0, 'user', math.ceil((1 - user_tokens) * 1000 / user_rate)}
+end
+local chat_tokens = tokens(KEYS[3], chat_rate, chat_burst)
+if chat_tokens < 1 then
+    return {0, 'chat', math.ceil((1 - chat_tokens) * 1000 / chat_rate)}
+end
+
+local function take(key, t, rate, burst)
+    redis.call('HSET', key, 'tokens', t - 1, 'ts', now)
+    redis.call('PEXPIRE', key, math.ceil(burst * 1000 / rate) + 1000)
+end
+take(KEYS[2], user_tokens, user_rate, user_burst)
+take(KEYS[3], chat_tokens, chat_rate, chat_burst)
+if check_dup then
+    redis.call('SET', KEYS[4], client_id, 'PX', dup_window * 1000)
+end
+return {1, '', 0}
+`
+
+// Allow - можно ли принять сообщение пользователя в чат. clientID - client_id сообщения
+// (может быть пустым).
+func (l *Limiter) Allow(ctx context.Context, userID, chatID int64, content, clientID string) (Decision, error) {
+	sum := sha1.Sum([]byte(content))
+	keys := []string{
+		configKey,
+		userBucketPrefix + strconv.FormatInt(userID, 10),
+		chatBucketPrefix + strconv.FormatInt(chatID, 10),
+		fmt.Sprintf("%s%d:%d:%s", duplicatePrefix, chatID, userID, hex.EncodeToString(sum[:])),
+	}
+	d := l.defaults
+	result, err := l.client.Eval(ctx, allowScript, keys,
+		time.Now().UnixMilli(), d.UserRate, d.UserBurst, d.ChatRate, d.ChatBurst, d.DuplicateWindowSeconds, clientID,
+		utf8.RuneCountInString(content), d.DuplicateMinLength,
+	).Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("ошибка проверки лимита сообщений: %w", err)
+	}
+	if len(result) != 3 {
+		return Decision{}, fmt.Errorf("неожиданный результат Lua-скрипта: %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	reason, _ := result[1].(string)
+	retryAfter, _ := result[2].(int64)
+	return Decision{
+		Allowed:    allowed == 1,
+		Reason:     reason,
+		RetryAfter: time.Duration(retryAfter) * time.Millisecond,
+	}, nil
+}
+
+// Limits - действующие настройки: сохранённые в Redis поверх значений по умолчанию
+func (l *Limiter) Limits(ctx context.Context) (Limits, error) {
+	values, err := l.client.HGetAll(ctx, configKey).Result()
+	if err != nil {
+		return Limits{}, fmt.Errorf("ошибка загрузки настроек лимитов: %w", err)
+	}
+
+	limits := l.defaults
+	floats := map[string]*float64{"user_rate": &limits.UserRate, "chat_rate": &limits.ChatRate}
+	for field, dst := range floats {
+		if value, ok := values[field]; ok {
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				*dst = f
+			}
+		}
+	}
+	ints := map[string]*int{
+		"user_burst":               &limits.UserBurst,
+		"chat_burst":               &limits.ChatBurst,
+		"duplicate_window_seconds": &limits.DuplicateWindowSeconds,
+		"duplicate_min_length":     &limits.DuplicateMinLength,
+	}
+	for field, dst := range ints {
+		if value, ok := values[field]; ok {
+			if n, err := strconv.Atoi(value); err == nil {
+				*dst = n
+			}
+		}
+	}
+	return limits, nil
+}
+
+// SetLimits - сохраняет настройки; все реплики применяют их со следующего сообщения
+func (l *Limiter) SetLimits(ctx context.Context, limits Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	err := l.client.HSet(ctx, configKey,
+		"user_rate", limits.UserRate,
+		"user_burst", limits.UserBurst,
+		"chat_rate", limits.ChatRate,
+		"chat_burst", limits.ChatBurst,
+		"duplicate_window_seconds", limits.DuplicateWindowSeconds,
+		"duplicate_min_length", limits.DuplicateMinLength,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения настроек лимитов: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestLimiter(t *testing.T) *Limiter {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewLimiter(client, Limits{
+		UserRate: 1, UserBurst: 100, ChatRate: 1, ChatBurst: 100,
+		DuplicateWindowSeconds: 10, DuplicateMinLength: 20,
+	})
+}
+
+func allow(t *testing.T, l *Limiter, content, clientID string) Decision {
+	t.Helper()
+	decision, err := l.Allow(context.Background(), 1, 1, content, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decision
+}
+
+func TestShortRepeatsAllowed(t *testing.T) {
+	l := newTestLimiter(t)
+	for i := 0; i < 3; i++ {
+		if d := allow(t, l, "ок", ""); !d.Allowed {
+			t.Fatalf("короткий повтор %d отклонён: %+v", i, d)
+		}
+	}
+}
+
+func TestLongRepeatRejected(t *testing.T) {
+	l := newTestLimiter(t)
+	const text = "Купите слона, скидки только сегодня!"
+
+	if d := allow(t, l, text, "a"); !d.Allowed {
+		t.Fatalf("первое сообщение отклонено: %+v", d)
+	}
+	// Переотправка с тем же client_id - не дубль
+	if d := allow(t, l, text, "a"); !d.Allowed {
+		t.Fatalf("переотправка отклонена: %+v", d)
+	}
+	d := allow(t, l, text, "b")
+	if d.Allowed || d.Reason != ReasonDuplicate || d.RetryAfter <= 0 {
+		t.Fatalf("повтор длинного текста должен быть отклонён как дубль: %+v", d)
+	}
+}
+
+func TestDuplicateMinLengthFromConfig(t *testing.T) {
+	l := newTestLimiter(t)
+	limits, err := l.Limits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits.DuplicateMinLength = 0
+	if err := l.SetLimits(context.Background(), limits); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := allow(t, l, "ок", ""); !d.Allowed {
+		t.Fatalf("первое сообщение отклонено: %+v", d)
+	}
+	if d := allow(t, l, "ок", ""); d.Allowed || d.Reason != ReasonDuplicate {
+		t.Fatalf("с duplicate_min_length = 0 проверяется любой текст: %+v", d)
+	}
+}
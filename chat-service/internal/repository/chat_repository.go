@@ -2,11 +2,30 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"chat-service/pkg/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrChatNotFound - чат не существует или пользователь не является его участником
+var ErrChatNotFound = errors.New("чат не найден")
+
+// ErrMessageNotFound - в чате нет сообщения с таким ID
+var ErrMessageNotFound = errors.New("сообщение не найдено")
+
+// ErrChatClosed - чат завершён или архивирован, писать в него нельзя
+var ErrChatClosed = errors.New("чат завершён")
+
+// Ошибки редактирования и удаления сообщений
+var (
+	ErrNotMessageAuthor = errors.New("изменять сообщение может только его отправитель")
+	ErrMessageDeleted   = errors.New("сообщение удалено")
+	ErrEditWindowClosed = errors.New("время на редактирование сообщения истекло")
 )
 
 // ChatRepository - репозиторий работы с БД
@@ -19,54 +38,569 @@ func NewChatRepository(db *gorm.DB) *ChatRepository {
 	return &ChatRepository{db: db}
 }
 
-// CreateChat - создаёт новый чат между двумя пользователями
-func (r *ChatRepository) CreateChat(ctx context.Context, user1ID, user2ID int64) (int64, error) {
-	chat := models.Chat{User1ID: user1ID, User2ID: user2ID}
+// CreateChat - создаёт новый чат с указанными участниками и, если botName не пуст, с ботом.
+// Если передан idempotencyKey и чат с таким ключом уже есть, возвращает его.
+func (r *ChatRepository) CreateChat(ctx context.Context, userIDs []int64, idempotencyKey, botName string) (*models.Chat, error) {
+	chat := models.Chat{Members: make([]models.ChatMember, 0, len(userIDs)), BotName: botName}
+	for _, userID := range userIDs {
+		chat.Members = append(chat.Members, models.ChatMember{UserID: userID})
+	}
+	if idempotencyKey != "" {
+		if existing, err := r.findChatByIdempotencyKey(ctx, idempotencyKey); err != nil || existing != nil {
+			return existing, err
+		}
+		chat.IdempotencyKey = &idempotencyKey
+	}
 
+	// Чат и участники создаются в одной транзакции
 	result := r.db.WithContext(ctx).Create(&chat)
 	if result.Error != nil {
-		return 0, fmt.Errorf("ошибка создания чата: %w", result.Error)
+		// Параллельный повтор с тем же ключом мог успеть создать чат раньше нас
+		if idempotencyKey != "" {
+			if existing, err := r.findChatByIdempotencyKey(ctx, idempotencyKey); err == nil && existing != nil {
+				return existing, nil
+			}
+		}
+		return nil, fmt.Errorf("ошибка создания чата: %w", result.Error)
 	}
 
-	log.Printf("✅ Чат создан: ID %d (пользователи: %d, %d)", chat.ID, user1ID, user2ID)
-	return chat.ID, nil
+	log.Printf("✅ Чат создан: ID %d (пользователи: %v)", chat.ID, userIDs)
+	return &chat, nil
 }
 
-// SaveMessage - сохраняет сообщение в базе данных
-func (r *ChatRepository) SaveMessage(ctx context.Context, message *models.Message) error {
-	result := r.db.WithContext(ctx).Create(message)
-	if result.Error != nil {
-		return fmt.Errorf("ошибка сохранения сообщения: %w", result.Error)
+// findChatByIdempotencyKey - ищет чат, созданный запросом с указанным ключом; nil, если такого нет
+func (r *ChatRepository) findChatByIdempotencyKey(ctx context.Context, key string) (*models.Chat, error) {
+	var chat models.Chat
+	err := r.db.WithContext(ctx).Where("idempotency_key = ?", key).First(&chat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска чата по ключу идемпотентности: %w", err)
+	}
+	log.Printf("♻️ Повторный запрос создания чата, возвращаем существующий чат %d", chat.ID)
+	return &chat, nil
+}
+
+// GetChat - получает чат вместе с участниками
+func (r *ChatRepository) GetChat(ctx context.Context, chatID int64) (*models.Chat, error) {
+	var chat models.Chat
+	err := r.db.WithContext(ctx).Preload("Members").First(&chat, chatID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки чата %d: %w", chatID, err)
+	}
+	return &chat, nil
+}
+
+// GetMemberChat - чат по публичному ID, если пользователь в нём участвует.
+// Для чужих и несуществующих чатов одинаково возвращает ErrChatNotFound.
+func (r *ChatRepository) GetMemberChat(ctx context.Context, publicID string, userID int64) (*models.Chat, error) {
+	var chat models.Chat
+	err := r.db.WithContext(ctx).Preload("Members").Where("public_id = ?", publicID).First(&chat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки чата %s: %w", publicID, err)
+	}
+	if !chat.IsMember(userID) {
+		return nil, ErrChatNotFound
+	}
+	return &chat, nil
+}
+
+// EndChat - завершает активный чат от имени участника и добавляет в него служебное сообщение
+// о выходе участника. Повторное завершение не меняет исходные данные о том, кто и почему
+// завершил чат, и возвращает nil вместо служебного сообщения.
+func (r *ChatRepository) EndChat(ctx context.Context, chatID, userID int64, reason string) (*models.Chat, *models.Message, error) {
+	chat, err := r.GetChat(ctx, chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !chat.IsMember(userID) {
+		return nil, nil, ErrChatNotFound
+	}
+
+	now := time.Now().UTC()
+	notice := models.Message{ChatID: chatID, SenderID: userID, SystemEvent: models.SystemMemberLeft, CreatedAt: now}
+	ended := false
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Chat{}).
+			Where("id = ? AND state = ?", chatID, models.ChatActive).
+			Updates(map[string]interface{}{"state": models.ChatEnded, "ended_at": now, "ended_by": userID, "end_reason": reason})
+		if result.Error != nil {
+			return fmt.Errorf("ошибка завершения чата %d: %w", chatID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			// Чат уже был завершён или архивирован раньше
+			return nil
+		}
+		ended = true
+		if err := tx.Create(&notice).Error; err != nil {
+			return fmt.Errorf("ошибка сохранения служебного сообщения: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ended {
+		return chat, nil, nil
+	}
+
+	chat.State, chat.EndedAt, chat.EndedBy, chat.EndReason = models.ChatEnded, &now, &userID, reason
+	log.Printf("🔚 Чат %d завершён пользователем %d (%s)", chatID, userID, reason)
+	return chat, &notice, nil
+}
+
+// AbandonedChat - активный чат, архивированный потому, что в нём давно не пишут
+type AbandonedChat struct {
+	Chat *models.Chat
+	// LastActivity - время последнего сообщения (или создания чата, если сообщений не было)
+	LastActivity time.Time
+}
+
+// ArchiveInactiveChats - архивирует чаты, завершённые раньше cutoff, и активные чаты
+// без сообщений после cutoff. Возвращает число архивированных чатов и заброшенные
+// активные чаты среди них.
+func (r *ChatRepository) ArchiveInactiveChats(ctx context.Context, cutoff time.Time) (int64, []AbandonedChat, error) {
+	now := time.Now().UTC()
+	archive := map[string]interface{}{"state": models.ChatArchived, "archived_at": now}
+	var archived int64
+	var abandonedIDs []int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Блокировка не даёт отправить сообщение в чат между выборкой и архивацией
+		recentMessages := tx.Model(&models.Message{}).Select("1").
+			Where("messages.chat_id = chats.id AND messages.created_at >= ?", cutoff)
+		err := tx.Model(&models.Chat{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("state = ? AND created_at < ? AND NOT EXISTS (?)", models.ChatActive, cutoff, recentMessages).
+			Pluck("id", &abandonedIDs).Error
+		if err != nil {
+			return fmt.Errorf("ошибка поиска заброшенных чатов: %w", err)
+		}
+		if len(abandonedIDs) > 0 {
+			result := tx.Model(&models.Chat{}).Where("id IN ?", abandonedIDs).Updates(archive)
+			if result.Error != nil {
+				return fmt.Errorf("ошибка архивации заброшенных чатов: %w", result.Error)
+			}
+			archived += result.RowsAffected
+		}
+
+		result := tx.Model(&models.Chat{}).
+			Where("state = ? AND ended_at < ?", models.ChatEnded, cutoff).
+			Updates(archive)
+		if result.Error != nil {
+			return fmt.Errorf("ошибка архивации завершённых чатов: %w", result.Error)
+		}
+		archived += result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(abandonedIDs) == 0 {
+		return archived, nil, nil
+	}
+
+	abandoned, err := r.abandonedChats(ctx, abandonedIDs)
+	return archived, abandoned, err
+}
+
+// abandonedChats - архивированные заброшенные чаты с участниками и временем последней активности
+func (r *ChatRepository) abandonedChats(ctx context.Context, chatIDs []int64) ([]AbandonedChat, error) {
+	var chats []models.Chat
+	if err := r.db.WithContext(ctx).Preload("Members").Find(&chats, chatIDs).Error; err != nil {
+		return nil, fmt.Errorf("ошибка загрузки заброшенных чатов: %w", err)
+	}
+	var lastMessages []struct {
+		ChatID int64
+		LastAt time.Time
+	}
+	err := r.db.WithContext(ctx).Model(&models.Message{}).
+		Select("chat_id, MAX(created_at) AS last_at").
+		Where("chat_id IN ?", chatIDs).
+		Group("chat_id").
+		Scan(&lastMessages).Error
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки последних сообщений заброшенных чатов: %w", err)
+	}
+	lastAt := make(map[int64]time.Time, len(lastMessages))
+	for _, m := range lastMessages {
+		lastAt[m.ChatID] = m.LastAt
+	}
+
+	abandoned := make([]AbandonedChat, 0, len(chats))
+	for i := range chats {
+		last, ok := lastAt[chats[i].ID]
+		if !ok {
+			last = chats[i].CreatedAt
+		}
+		abandoned = append(abandoned, AbandonedChat{Chat: &chats[i], LastActivity: last})
+	}
+	return abandoned, nil
+}
+
+// SaveMessage - сохраняет сообщение в базе данных. Если у сообщения есть ClientID и
+// отправитель уже присылал его в этот чат, заполняет message сохранённым ранее
+// сообщением и возвращает duplicate = true.
+func (r *ChatRepository) SaveMessage(ctx context.Context, message *models.Message) (duplicate bool, err error) {
+	if message.ClientID != nil {
+		if existing, err := r.FindMessageByClientID(ctx, message.ChatID, message.SenderID, *message.ClientID); err != nil || existing != nil {
+			if existing != nil {
+				*message = *existing
+			}
+			return existing != nil, err
+		}
+	}
+
+	// Блокировка строки чата не даёт завершить чат между проверкой состояния и вставкой
+	var createErr error
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockActiveChat(tx, message.ChatID); err != nil {
+			return err
+		}
+		createErr = tx.Create(message).Error
+		return createErr
+	})
+	if createErr != nil {
+		// Параллельный повтор с тем же ClientID мог успеть сохранить сообщение раньше нас
+		if message.ClientID != nil {
+			if existing, err := r.FindMessageByClientID(ctx, message.ChatID, message.SenderID, *message.ClientID); err == nil && existing != nil {
+				*message = *existing
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("ошибка сохранения сообщения: %w", createErr)
+	}
+	if err != nil {
+		return false, err
 	}
 
 	log.Printf("📩 Сообщение сохранено (чат %d): %s", message.ChatID, message.Content)
+	return false, nil
+}
+
+// FindMessageByClientID - ищет сообщение отправителя с тем же ClientID в том же чате; nil, если такого нет
+func (r *ChatRepository) FindMessageByClientID(ctx context.Context, chatID, senderID int64, clientID string) (*models.Message, error) {
+	var existing models.Message
+	err := r.db.WithContext(ctx).
+		Where("chat_id = ? AND sender_id = ? AND client_id = ?", chatID, senderID, clientID).
+		First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска сообщения по client_id: %w", err)
+	}
+	log.Printf("♻️ Повторная отправка сообщения %d в чат %d", existing.ID, existing.ChatID)
+	return &existing, nil
+}
+
+// EditMessage - меняет текст сообщения отправителя, если с отправки прошло не больше window.
+// Прежний текст сохраняется в истории изменений.
+func (r *ChatRepository) EditMessage(ctx context.Context, chatID, messageID, senderID int64, content string, window time.Duration) (*models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockActiveChat(tx, chatID); err != nil {
+			return err
+		}
+		if err := lockAuthorMessage(tx, chatID, messageID, senderID, &message); err != nil {
+			return err
+		}
+		if message.IsDeleted() {
+			return ErrMessageDeleted
+		}
+		if time.Since(message.CreatedAt) > window {
+			return ErrEditWindowClosed
+		}
+
+		now := time.Now().UTC()
+		if err := tx.Create(&models.MessageEdit{MessageID: message.ID, Action: models.MessageEdited, Content: message.Content}).Error; err != nil {
+			return fmt.Errorf("ошибка сохранения истории изменений: %w", err)
+		}
+		if err := tx.Model(&message).Updates(map[string]interface{}{"content": content, "edited_at": now}).Error; err != nil {
+			return fmt.Errorf("ошибка редактирования сообщения %d: %w", messageID, err)
+		}
+		message.Content, message.EditedAt = content, &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("✏️ Сообщение %d отредактировано (чат %d)", messageID, chatID)
+	return &message, nil
+}
+
+// DeleteMessage - удаляет сообщение отправителя для всех: в истории остаётся надгробие без текста,
+// а сам текст переносится в историю изменений. Повторное удаление ничего не меняет.
+func (r *ChatRepository) DeleteMessage(ctx context.Context, chatID, messageID, senderID int64) (*models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockActiveChat(tx, chatID); err != nil {
+			return err
+		}
+		if err := lockAuthorMessage(tx, chatID, messageID, senderID, &message); err != nil {
+			return err
+		}
+		if message.IsDeleted() {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		if err := tx.Create(&models.MessageEdit{MessageID: message.ID, Action: models.MessageDeleted, Content: message.Content}).Error; err != nil {
+			return fmt.Errorf("ошибка сохранения истории изменений: %w", err)
+		}
+		if err := tx.Model(&message).Updates(map[string]interface{}{"content": "", "deleted_at": now}).Error; err != nil {
+			return fmt.Errorf("ошибка удаления сообщения %d: %w", messageID, err)
+		}
+		message.Content, message.DeletedAt = "", &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("🗑️ Сообщение %d удалено (чат %d)", messageID, chatID)
+	return &message, nil
+}
+
+// lockActiveChat - блокирует строку чата до конца транзакции (на чтение: сообщения в один чат
+// сохраняются параллельно, а завершение чата ждёт их) и проверяет, что чат активен
+func lockActiveChat(tx *gorm.DB, chatID int64) error {
+	var chat models.Chat
+	err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+		Select("id", "state").
+		Where("id = ?", chatID).
+		First(&chat).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrChatNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка проверки состояния чата %d: %w", chatID, err)
+	}
+	if chat.State != models.ChatActive {
+		return ErrChatClosed
+	}
 	return nil
 }
 
-// GetChatHistory - получает историю сообщений для указанного чата
-func (r *ChatRepository) GetChatHistory(ctx context.Context, chatID int64) ([]models.Message, error) {
-	var messages []models.Message
+// lockAuthorMessage - блокирует сообщение чата до конца транзакции и проверяет, что его
+// отправил senderID
+func lockAuthorMessage(tx *gorm.DB, chatID, messageID, senderID int64, message *models.Message) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("chat_id = ? AND id = ?", chatID, messageID).
+		First(message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки сообщения %d: %w", messageID, err)
+	}
+	if message.FromBot || message.SystemEvent != "" || message.SenderID != senderID {
+		return ErrNotMessageAuthor
+	}
+	return nil
+}
 
-	result := r.db.WithContext(ctx).
-		Where("chat_id = ?", chatID).
-		Order("created_at ASC").
-		Find(&messages)
+// HistoryQuery - какую страницу истории загрузить: не больше Limit сообщений с ID меньше BeforeID
+// или больше AfterID. Если оба не заданы - самые новые сообщения.
+type HistoryQuery struct {
+	BeforeID int64
+	AfterID  int64
+	Limit    int
+}
 
-	if result.Error != nil {
-		return nil, fmt.Errorf("ошибка загрузки истории сообщений: %w", result.Error)
+// GetChatHistory - получает страницу истории сообщений чата (keyset-пагинация по ID сообщения)
+func (r *ChatRepository) GetChatHistory(ctx context.Context, chatID int64, q HistoryQuery) (*models.HistoryPage, error) {
+	query := r.db.WithContext(ctx).Where("chat_id = ?", chatID)
+	newestFirst := q.AfterID == 0
+	switch {
+	case q.AfterID > 0:
+		query = query.Where("id > ?", q.AfterID).Order("id ASC")
+	case q.BeforeID > 0:
+		query = query.Where("id < ?", q.BeforeID).Order("id DESC")
+	default:
+		query = query.Order("id DESC")
+	}
+
+	// Лишнее сообщение показывает, есть ли ещё страница в ту же сторону
+	messages := make([]models.Message, 0, q.Limit+1)
+	if err := query.Limit(q.Limit + 1).Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("ошибка загрузки истории сообщений: %w", err)
+	}
+	hasMore := len(messages) > q.Limit
+	if hasMore {
+		messages = messages[:q.Limit]
+	}
+	if newestFirst {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	page := &models.HistoryPage{Messages: messages}
+	if len(messages) == 0 {
+		return page, nil
+	}
+	first, last := messages[0].ID, messages[len(messages)-1].ID
+	if newestFirst {
+		if hasMore {
+			page.PrevCursor = &first
+		}
+		if q.BeforeID > 0 {
+			page.NextCursor = &last
+		}
+	} else {
+		page.PrevCursor = &first
+		if hasMore {
+			page.NextCursor = &last
+		}
 	}
 
-	log.Printf("📜 Загружена история сообщений для чата %d", chatID)
-	return messages, nil
+	log.Printf("📜 Загружена страница истории чата %d: %d сообщений", chatID, len(messages))
+	return page, nil
 }
 
-func (r *ChatRepository) GetUserChats(ctx context.Context, userID int64) ([]models.Chat, error) {
+// GetUserChats - получает чаты, в которых участвует пользователь, вместе со списком участников,
+// числом непрочитанных и последним сообщением. Если state не пуст, возвращает только чаты
+// в этом состоянии. Число запросов не зависит от числа чатов.
+func (r *ChatRepository) GetUserChats(ctx context.Context, userID int64, state string) ([]models.ChatSummary, error) {
+	query := r.db.WithContext(ctx).
+		Preload("Members").
+		Joins("JOIN chat_members ON chat_members.chat_id = chats.id").
+		Where("chat_members.user_id = ?", userID)
+	if state != "" {
+		query = query.Where("chats.state = ?", state)
+	}
 	var chats []models.Chat
-	result := r.db.WithContext(ctx).
-		Where("user1_id = ? OR user2_id = ?", userID, userID).
-		Find(&chats)
+	result := query.Order("chats.id ASC").Find(&chats)
 	if result.Error != nil {
 		return nil, fmt.Errorf("ошибка загрузки чатов пользователя %d: %w", userID, result.Error)
 	}
-	return chats, nil
+	summaries := make([]models.ChatSummary, 0, len(chats))
+	if len(chats) == 0 {
+		return summaries, nil
+	}
+
+	// Непрочитанные - чужие неудалённые сообщения после курсора прочтения пользователя
+	var unread []struct {
+		ChatID int64
+		Count  int64
+	}
+	err := r.db.WithContext(ctx).Table("messages").
+		Select("messages.chat_id, COUNT(*) AS count").
+		Joins("JOIN chat_members ON chat_members.chat_id = messages.chat_id AND chat_members.user_id = ?", userID).
+		Where("messages.id > chat_members.last_read_message_id AND messages.sender_id <> ? AND messages.deleted_at IS NULL", userID).
+		Group("messages.chat_id").
+		Scan(&unread).Error
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подсчёта непрочитанных сообщений пользователя %d: %w", userID, err)
+	}
+	unreadByChat := make(map[int64]int64, len(unread))
+	for _, u := range unread {
+		unreadByChat[u.ChatID] = u.Count
+	}
+
+	chatIDs := make([]int64, 0, len(chats))
+	for _, chat := range chats {
+		chatIDs = append(chatIDs, chat.ID)
+	}
+	var lastMessages []models.Message
+	err = r.db.WithContext(ctx).
+		Where("id IN (?)", r.db.Model(&models.Message{}).Select("MAX(id)").Where("chat_id IN ?", chatIDs).Group("chat_id")).
+		Find(&lastMessages).Error
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки последних сообщений чатов пользователя %d: %w", userID, err)
+	}
+	lastByChat := make(map[int64]*models.Message, len(lastMessages))
+	for i := range lastMessages {
+		lastByChat[lastMessages[i].ChatID] = &lastMessages[i]
+	}
+
+	for _, chat := range chats {
+		summaries = append(summaries, models.ChatSummary{
+			Chat:        chat,
+			UnreadCount: unreadByChat[chat.ID],
+			LastMessage: lastByChat[chat.ID],
+		})
+	}
+	return summaries, nil
+}
+
+// MarkRead - сдвигает курсор прочтения участника на сообщение messageID этого чата.
+// Курсор только растёт; возвращает false, если сообщение уже было прочитано.
+func (r *ChatRepository) MarkRead(ctx context.Context, chatID, userID, messageID int64) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Message{}).
+		Where("chat_id = ? AND id = ?", chatID, messageID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки сообщения %d: %w", messageID, err)
+	}
+	if count == 0 {
+		return false, ErrMessageNotFound
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.ChatMember{}).
+		Where("chat_id = ? AND user_id = ? AND last_read_message_id < ?", chatID, userID, messageID).
+		Update("last_read_message_id", messageID)
+	if result.Error != nil {
+		return false, fmt.Errorf("ошибка обновления курсора прочтения чата %d: %w", chatID, result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ActiveChatIDs - активные чаты, в которых участвует пользователь
+func (r *ChatRepository) ActiveChatIDs(ctx context.Context, userID int64) ([]int64, error) {
+	var chatIDs []int64
+	err := r.db.WithContext(ctx).Model(&models.Chat{}).
+		Joins("JOIN chat_members ON chat_members.chat_id = chats.id").
+		Where("chat_members.user_id = ? AND chats.state = ?", userID, models.ChatActive).
+		Pluck("chats.id", &chatIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки активных чатов пользователя %d: %w", userID, err)
+	}
+	return chatIDs, nil
+}
+
+// GetUserSettings - настройки пользователя; для пользователя без записи - значения по умолчанию
+func (r *ChatRepository) GetUserSettings(ctx context.Context, userID int64) (*models.UserSettings, error) {
+	settings := models.UserSettings{UserID: userID}
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Limit(1).Find(&settings).Error
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки настроек пользователя %d: %w", userID, err)
+	}
+	return &settings, nil
+}
+
+// SaveUserSettings - создаёт или обновляет настройки пользователя
+func (r *ChatRepository) SaveUserSettings(ctx context.Context, settings *models.UserSettings) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(settings).Error
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения настроек пользователя %d: %w", settings.UserID, err)
+	}
+	return nil
+}
+
+// HiddenLastSeen - кто из пользователей скрыл время последнего визита
+func (r *ChatRepository) HiddenLastSeen(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	hidden := make(map[int64]bool)
+	if len(userIDs) == 0 {
+		return hidden, nil
+	}
+	var ids []int64
+	err := r.db.WithContext(ctx).Model(&models.UserSettings{}).
+		Where("user_id IN ? AND hide_last_seen = ?", userIDs, true).
+		Pluck("user_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки настроек приватности: %w", err)
+	}
+	for _, id := range ids {
+		hidden[id] = true
+	}
+	return hidden, nil
 }
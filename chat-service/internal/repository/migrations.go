@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"fmt"
+	"log"
+
+	"chat-service/pkg/models"
+	"gorm.io/gorm"
+)
+
+// MigrateChatMembers - переносит участников из устаревших колонок chats.user1_id/user2_id
+// в таблицу chat_members и удаляет эти колонки. Повторный запуск безопасен.
+func MigrateChatMembers(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.Chat{}, "user1_id") {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, column := range []string{"user1_id", "user2_id"} {
+			err := tx.Exec(
+				"INSERT IGNORE INTO chat_members (chat_id, user_id, joined_at) SELECT id, " + column + ", created_at FROM chats",
+			).Error
+			if err != nil {
+				return fmt.Errorf("ошибка переноса %s: %w", column, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []string{"user1_id", "user2_id"} {
+		if err := migrator.DropColumn(&models.Chat{}, column); err != nil {
+			return fmt.Errorf("ошибка удаления колонки %s: %w", column, err)
+		}
+	}
+	log.Println("✅ Участники чатов перенесены в таблицу chat_members")
+	return nil
+}
+
+// BackfillChatPublicIDs - выдаёт PublicID чатам, созданным до его появления. Повторный запуск безопасен.
+func BackfillChatPublicIDs(db *gorm.DB) error {
+	result := db.Exec("UPDATE chats SET public_id = UUID() WHERE public_id IS NULL OR public_id = ''")
+	if result.Error != nil {
+		return fmt.Errorf("ошибка заполнения public_id: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("✅ Выданы публичные ID для %d чатов", result.RowsAffected)
+	}
+	return nil
+}
+
+// DropLegacyMessageIndex - удаляет одиночный индекс messages.chat_id: его заменил
+// составной индекс (chat_id, id). Повторный запуск безопасен.
+func DropLegacyMessageIndex(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasIndex(&models.Message{}, "idx_messages_chat_id") {
+		return nil
+	}
+	if err := migrator.DropIndex(&models.Message{}, "idx_messages_chat_id"); err != nil {
+		return fmt.Errorf("ошибка удаления индекса idx_messages_chat_id: %w", err)
+	}
+	log.Println("✅ Удалён устаревший индекс idx_messages_chat_id")
+	return nil
+}
+
+// BackfillChatStates - переводит в состояние ended чаты, завершённые до появления
+// состояний (колонка state создаётся со значением active). Повторный запуск безопасен.
+func BackfillChatStates(db *gorm.DB) error {
+	result := db.Model(&models.Chat{}).
+		Where("state = ? AND ended_at IS NOT NULL", models.ChatActive).
+		Update("state", models.ChatEnded)
+	if result.Error != nil {
+		return fmt.Errorf("ошибка заполнения состояния чатов: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("✅ Состояние ended выставлено %d завершённым чатам", result.RowsAffected)
+	}
+	return nil
+}
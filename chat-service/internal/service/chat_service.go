@@ -2,30 +2,173 @@ package service
 
 import (
 	"context"
+	"errors"
 	"log"
+	"time"
 
+	"chat-service/internal/bot"
 	"chat-service/internal/grpc/chatpb"
+	"chat-service/internal/lifecycle"
 	"chat-service/internal/repository"
+	"chat-service/pkg/models"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// ChatNotifier - доставка событий участникам чата, подключённым по WebSocket
+type ChatNotifier interface {
+	NotifyChat(chatID int64, event interface{})
+	// NotifyMessage - новое сообщение чата, сохранённое не через WebSocket (например, служебное)
+	NotifyMessage(chatID int64, msg models.Message)
+}
+
 // ChatService - сервис управления чатами
 type ChatService struct {
-	chatRepo *repository.ChatRepository
+	chatRepo  *repository.ChatRepository
+	notifier  ChatNotifier
+	bots      *bot.Registry
+	lifecycle *lifecycle.Publisher // события о завершении чатов для других сервисов
 }
 
 // NewChatService - конструктор сервиса
-func NewChatService(chatRepo *repository.ChatRepository) *ChatService {
-	return &ChatService{chatRepo: chatRepo}
+func NewChatService(chatRepo *repository.ChatRepository, notifier ChatNotifier, bots *bot.Registry, publisher *lifecycle.Publisher) *ChatService {
+	return &ChatService{chatRepo: chatRepo, notifier: notifier, bots: bots, lifecycle: publisher}
 }
 
+// minChatMembers - в чате должно быть хотя бы два участника (или один, если второй - бот)
+const minChatMembers = 2
+
 // CreateChat - gRPC-метод создания чата
 func (s *ChatService) CreateChat(ctx context.Context, req *chatpb.CreateChatRequest) (*chatpb.CreateChatResponse, error) {
-	chatID, err := s.chatRepo.CreateChat(ctx, req.User1Id, req.User2Id)
+	userIDs := uniqueIDs(req.UserIds)
+	minMembers := minChatMembers
+	if req.Bot != "" {
+		if _, ok := s.bots.Get(req.Bot); !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "неизвестный бот %q, доступны: %v", req.Bot, s.bots.Names())
+		}
+		minMembers--
+	}
+	if len(userIDs) < minMembers {
+		return nil, status.Errorf(codes.InvalidArgument, "в чате должно быть не меньше %d разных участников", minMembers)
+	}
+
+	chat, err := s.chatRepo.CreateChat(ctx, userIDs, req.IdempotencyKey, req.Bot)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Bot != "" {
+		log.Printf("🤖 Чат с ботом %q создан: %d (пользователи: %v)", req.Bot, chat.ID, userIDs)
+	} else {
+		log.Printf("✅ Чат создан: %d (пользователи: %v)", chat.ID, userIDs)
+	}
+
+	return &chatpb.CreateChatResponse{ChatId: chat.ID, PublicId: chat.PublicID}, nil
+}
+
+// GetUserChats - gRPC-метод получения чатов пользователя
+func (s *ChatService) GetUserChats(ctx context.Context, req *chatpb.GetUserChatsRequest) (*chatpb.GetUserChatsResponse, error) {
+	chats, err := s.chatRepo.GetUserChats(ctx, req.UserId, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &chatpb.GetUserChatsResponse{Chats: make([]*chatpb.ChatInfo, 0, len(chats))}
+	for i := range chats {
+		resp.Chats = append(resp.Chats, &chatpb.ChatInfo{
+			ChatId:    chats[i].ID,
+			CreatedAt: chats[i].CreatedAt.UTC().Format(time.RFC3339),
+			MemberIds: chats[i].MemberIDs(),
+			EndedAt:   formatTime(chats[i].EndedAt),
+			Bot:       chats[i].BotName,
+			PublicId:  chats[i].PublicID,
+			State:     chats[i].State,
+		})
+	}
+	return resp, nil
+}
+
+// EndChat - gRPC-метод завершения чата участником. Остальные участники получают событие по WebSocket.
+func (s *ChatService) EndChat(ctx context.Context, req *chatpb.EndChatRequest) (*chatpb.EndChatResponse, error) {
+	chat, notice, err := s.chatRepo.EndChat(ctx, req.ChatId, req.UserId, req.Reason)
+	if errors.Is(err, repository.ErrChatNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("✅ Чат создан: %d (пользователи: %d, %d)", chatID, req.User1Id, req.User2Id)
+	if notice != nil {
+		s.notifier.NotifyMessage(chat.ID, *notice)
+		s.notifier.NotifyChat(chat.ID, models.ChatEndedEvent{
+			Event:   "chat_ended",
+			ChatID:  chat.PublicID,
+			Reason:  chat.EndReason,
+			EndedAt: *chat.EndedAt,
+		})
+		if err := s.lifecycle.ChatEnded(ctx, lifecycle.EndedByMember(chat)); err != nil {
+			log.Println("❌", err)
+		}
+	}
+
+	return &chatpb.EndChatResponse{
+		MemberIds: chat.MemberIDs(),
+		CreatedAt: chat.CreatedAt.UTC().Format(time.RFC3339),
+		EndedAt:   formatTime(chat.EndedAt),
+	}, nil
+}
 
-	return &chatpb.CreateChatResponse{ChatId: chatID}, nil
+// RunArchiver - раз в interval архивирует чаты, неактивные дольше after, пока не отменён ctx.
+// О заброшенных активных чатах сообщает другим сервисам как о завершённых.
+func (s *ChatService) RunArchiver(ctx context.Context, interval, after time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		archived, abandoned, err := s.chatRepo.ArchiveInactiveChats(ctx, time.Now().UTC().Add(-after))
+		if err != nil {
+			log.Println("❌", err)
+		} else if archived > 0 {
+			log.Printf("🗄️ Архивировано чатов: %d", archived)
+		}
+		for _, a := range abandoned {
+			err := s.lifecycle.ChatEnded(ctx, lifecycle.ChatEnded{
+				ChatID:    a.Chat.ID,
+				MemberIDs: a.Chat.MemberIDs(),
+				CreatedAt: a.Chat.CreatedAt,
+				EndedAt:   a.LastActivity,
+				Reason:    lifecycle.ReasonArchived,
+			})
+			if err != nil {
+				log.Println("❌", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// formatTime - время в RFC3339 или пустая строка для nil
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// uniqueIDs - убирает повторы, сохраняя порядок
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
 }
@@ -1,11 +1,95 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Состояния жизненного цикла чата
+const (
+	ChatActive   = "active"   // чат идёт, в него можно писать
+	ChatEnded    = "ended"    // чат завершил один из участников
+	ChatArchived = "archived" // чат давно завершён или заброшен; доступен только для чтения
+)
 
 // Chat - структура для хранения информации о чате
 type Chat struct {
-	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
-	User1ID   int64     `gorm:"not null" json:"user1_id"`
-	User2ID   int64     `gorm:"not null" json:"user2_id"`
+	// ID - внутренний ключ; клиентам чат известен только по PublicID
+	ID int64 `gorm:"primaryKey;autoIncrement" json:"-"`
+	// PublicID - непредсказуемый ID чата для клиентов, чтобы чужие чаты нельзя было перебрать
+	PublicID  string    `gorm:"size:36;uniqueIndex" json:"id"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	// Members - участники чата (два в обычном чате, больше - в групповой комнате)
+	Members []ChatMember `gorm:"foreignKey:ChatID;constraint:OnDelete:CASCADE" json:"members"`
+	// IdempotencyKey - ключ запроса на создание чата, защищает от дублей при повторных gRPC-вызовах
+	IdempotencyKey *string `gorm:"size:64;uniqueIndex" json:"-"`
+	// EndedAt, EndedBy, EndReason - кто, когда и почему завершил чат (nil, пока чат идёт)
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	EndedBy   *int64     `json:"ended_by,omitempty"`
+	EndReason string     `gorm:"size:32" json:"end_reason,omitempty"`
+	// State - состояние жизненного цикла: ChatActive, ChatEnded или ChatArchived
+	State string `gorm:"size:16;not null;default:active;index" json:"state"`
+	// ArchivedAt - когда чат был архивирован (nil, пока не архивирован)
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	// BotName - имя бота-собеседника; пусто для чатов между людьми
+	BotName string `gorm:"size:32" json:"bot,omitempty"`
+}
+
+// BeforeCreate - выдаёт чату PublicID; новый чат активен
+func (c *Chat) BeforeCreate(*gorm.DB) error {
+	if c.PublicID == "" {
+		c.PublicID = uuid.NewString()
+	}
+	if c.State == "" {
+		c.State = ChatActive
+	}
+	return nil
+}
+
+// MemberIDs - ID всех участников чата
+func (c *Chat) MemberIDs() []int64 {
+	ids := make([]int64, 0, len(c.Members))
+	for _, m := range c.Members {
+		ids = append(ids, m.UserID)
+	}
+	return ids
+}
+
+// IsActive - в чат ещё можно писать
+func (c *Chat) IsActive() bool {
+	return c.State == ChatActive
+}
+
+// IsBotChat - собеседник в чате - бот
+func (c *Chat) IsBotChat() bool {
+	return c.BotName != ""
+}
+
+// IsMember - участвует ли пользователь в чате
+func (c *Chat) IsMember(userID int64) bool {
+	for _, m := range c.Members {
+		if m.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ChatMember - участие пользователя в чате
+type ChatMember struct {
+	ChatID   int64     `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	UserID   int64     `gorm:"primaryKey;autoIncrement:false;index" json:"user_id"`
+	JoinedAt time.Time `gorm:"autoCreateTime" json:"joined_at"`
+	// LastReadMessageID - последнее сообщение, прочитанное участником (0 - ничего не прочитано)
+	LastReadMessageID int64 `gorm:"not null;default:0" json:"last_read_message_id"`
+}
+
+// ChatSummary - чат в списке чатов пользователя: сколько в нём непрочитанных сообщений
+// и каким сообщением он заканчивается
+type ChatSummary struct {
+	Chat
+	UnreadCount int64    `json:"unread_count"`
+	LastMessage *Message `json:"last_message,omitempty"`
 }
@@ -4,9 +4,57 @@ import "time"
 
 // Message - структура для хранения сообщений в чате
 type Message struct {
+	// Индекс (chat_id, id) обслуживает постраничную выдачу истории по ID сообщения
+	ID       int64 `gorm:"primaryKey;autoIncrement;index:idx_messages_chat_id_id,priority:2" json:"id"`
+	ChatID   int64 `gorm:"not null;index:idx_messages_chat_id_id,priority:1;uniqueIndex:idx_messages_client_id,priority:1" json:"-"`
+	SenderID int64 `gorm:"not null;uniqueIndex:idx_messages_client_id,priority:2" json:"sender_id"` // 0 для сообщений бота
+	// ClientID - ID, сгенерированный клиентом: повторная отправка с тем же ID не создаёт дубль
+	ClientID  *string   `gorm:"size:64;uniqueIndex:idx_messages_client_id,priority:3" json:"client_id,omitempty"`
+	Content   string    `gorm:"type:text;not null" json:"content"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	// FromBot - сообщение написал бот-собеседник
+	FromBot bool `gorm:"not null;default:false" json:"from_bot"`
+	// EditedAt - когда сообщение последний раз редактировали (nil - не редактировалось)
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// DeletedAt - когда отправитель удалил сообщение для всех. Строка остаётся в истории
+	// пустым надгробием, прежний текст хранится только в MessageEdit.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// SystemEvent - код служебного сообщения (например, SystemMemberLeft); текст у таких
+	// сообщений пуст, клиент показывает его по коду. SenderID - участник, о котором сообщение.
+	SystemEvent string `gorm:"size:32" json:"system_event,omitempty"`
+}
+
+// Служебные сообщения чата
+const (
+	SystemMemberLeft = "member_left" // участник завершил чат и вышел из него
+)
+
+// IsDeleted - сообщение удалено отправителем
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// Действия в истории изменений сообщения
+const (
+	MessageEdited  = "edit"
+	MessageDeleted = "delete"
+)
+
+// MessageEdit - прежний текст сообщения до редактирования или удаления; клиентам
+// не отдаётся и нужен для модерации
+type MessageEdit struct {
 	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
-	ChatID    int64     `gorm:"not null;index" json:"chat_id"`
-	SenderID  int64     `gorm:"not null" json:"sender_id"`
+	MessageID int64     `gorm:"not null;index" json:"message_id"`
+	Action    string    `gorm:"size:16;not null" json:"action"`
 	Content   string    `gorm:"type:text;not null" json:"content"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
+
+// HistoryPage - страница истории сообщений, от старых к новым.
+// PrevCursor передаётся в before для более старой страницы, NextCursor - в after для более новой;
+// курсор не задан, если в эту сторону сообщений больше нет.
+type HistoryPage struct {
+	Messages   []Message `json:"messages"`
+	PrevCursor *int64    `json:"prev_cursor,omitempty"`
+	NextCursor *int64    `json:"next_cursor,omitempty"`
+}
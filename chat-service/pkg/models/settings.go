@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// UserSettings - настройки пользователя в чатах. Пользователи без записи используют
+// значения по умолчанию.
+type UserSettings struct {
+	UserID int64 `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	// HideLastSeen - не показывать собеседникам, когда пользователь был в сети
+	HideLastSeen bool      `gorm:"not null;default:false" json:"hide_last_seen"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
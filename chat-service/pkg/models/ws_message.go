@@ -2,8 +2,99 @@ package models
 
 import "time"
 
-type WsMessage struct {
-	SenderID  int64     `json:"senderId"`
-	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
+// Payload кадров WebSocket; сами кадры описаны в pkg/protocol
+
+// SendMessagePayload - payload кадра message от клиента. Отправителя сервер берёт
+// из авторизации, а время проставляет сам. ClientID генерирует клиент, чтобы повторная
+// отправка того же сообщения после обрыва связи не создала дубль.
+type SendMessagePayload struct {
+	Content  string `json:"content"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// Статусы доставки в кадре ack
+const (
+	AckSent      = "sent"      // сообщение сохранено сервером
+	AckDelivered = "delivered" // сообщение получил собеседник
+)
+
+// AckPayload - payload кадра ack. Статус sent получает отправитель после сохранения
+// сообщения, delivered рассылается в чат, когда сообщение дошло до собеседника.
+type AckPayload struct {
+	MessageID int64     `json:"message_id"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TypingPayload - payload кадра typing: пользователь начал (Typing = true) или перестал печатать.
+// UserID заполняет сервер при рассылке.
+type TypingPayload struct {
+	Typing bool  `json:"typing"`
+	UserID int64 `json:"user_id,omitempty"`
+}
+
+// ReadPayload - payload кадра read: участник прочитал сообщения чата до MessageID включительно.
+// UserID заполняет сервер при рассылке.
+type ReadPayload struct {
+	MessageID int64 `json:"message_id"`
+	UserID    int64 `json:"user_id,omitempty"`
+}
+
+// EditMessagePayload - payload кадра edit от клиента: новый текст своего сообщения
+type EditMessagePayload struct {
+	MessageID int64  `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// DeleteMessagePayload - payload кадра delete от клиента: удалить своё сообщение для всех
+type DeleteMessagePayload struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// HistoryRequestPayload - payload запроса истории: сообщения с ID меньше Before (более старые)
+// или больше After (пропущенные при переподключении), не больше Limit
+type HistoryRequestPayload struct {
+	Before int64 `json:"before,omitempty"`
+	After  int64 `json:"after,omitempty"`
+	Limit  int   `json:"limit,omitempty"`
+}
+
+// WelcomeEvent - первое служебное событие соединения: согласованная версия протокола
+type WelcomeEvent struct {
+	Event   string `json:"event"` // всегда "welcome"
+	Version int    `json:"version"`
+}
+
+// BotChatEvent - отправляется при подключении к чату с ботом, чтобы клиент явно показал,
+// что собеседник не человек
+type BotChatEvent struct {
+	Event  string `json:"event"`   // всегда "bot_chat"
+	ChatID string `json:"chat_id"` // PublicID чата
+	Bot    string `json:"bot"`
+}
+
+// ResumedEvent - отправляется после сообщений, пропущенных клиентом до переподключения
+// (параметр last_id). Если пропущено больше, чем досылается сразу, NextCursor - ID, с которого
+// остальное нужно догрузить через историю (кадр history с after или ?after=).
+type ResumedEvent struct {
+	Event      string `json:"event"` // всегда "resumed"
+	Replayed   int    `json:"replayed"`
+	NextCursor *int64 `json:"next_cursor,omitempty"`
+}
+
+// ChatEndedEvent - уведомление участников о завершении чата
+type ChatEndedEvent struct {
+	Event   string    `json:"event"`   // всегда "chat_ended"
+	ChatID  string    `json:"chat_id"` // PublicID чата
+	Reason  string    `json:"reason"`
+	EndedAt time.Time `json:"ended_at"`
+}
+
+// PresencePayload - payload кадра presence: собеседник в сети или нет. LastSeen - когда он
+// был в сети последний раз; не заполняется, пока он онлайн или если он скрыл время визита.
+type PresencePayload struct {
+	UserID   int64      `json:"user_id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
 }
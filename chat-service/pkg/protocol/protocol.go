@@ -0,0 +1,146 @@
+// Package protocol - формат кадров WebSocket чата. Каждый кадр в обе стороны - конверт
+// {type, id, payload}; версия протокола согласуется подпротоколом WebSocket (chat.v1).
+package protocol
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Version - текущая версия протокола
+const Version = 1
+
+// Subprotocol - подпротокол WebSocket текущей версии; клиент перечисляет поддерживаемые им
+// версии в Sec-WebSocket-Protocol, сервер выбирает одну из них
+const Subprotocol = "chat.v1"
+
+// subprotocolPrefix - общий префикс подпротоколов всех версий
+const subprotocolPrefix = "chat.v"
+
+// maxIDLength - максимальная длина id кадра
+const maxIDLength = 64
+
+// Типы кадров
+const (
+	TypeMessage  = "message"  // клиент: отправить сообщение; сервер: новое сообщение чата
+	TypeAck      = "ack"      // сервер: кадр клиента с этим id принят
+	TypeTyping   = "typing"   // индикатор набора текста
+	TypeRead     = "read"     // отметка о прочтении
+	TypeEdit     = "edit"     // клиент: изменить своё сообщение; сервер: сообщение изменено
+	TypeDelete   = "delete"   // клиент: удалить своё сообщение; сервер: сообщение удалено
+	TypeHistory  = "history"  // клиент: запрос старой страницы истории; сервер: страница
+	TypeSystem   = "system"   // сервер: служебное событие чата
+	TypeError    = "error"    // сервер: кадр клиента отклонён
+	TypePresence = "presence" // сервер: собеседник появился в сети или ушёл
+)
+
+// Коды ошибок в кадре error
+const (
+	ErrBadFrame        = "bad_frame"        // кадр - не JSON-конверт
+	ErrUnsupportedType = "unsupported_type" // неизвестный тип кадра
+	ErrInvalidPayload  = "invalid_payload"  // payload не подходит к типу кадра
+	ErrNotFound        = "not_found"        // сообщение не найдено
+	ErrForbidden       = "forbidden"        // действие запрещено (чужое сообщение, истекло время)
+	ErrChatClosed      = "chat_closed"      // чат завершён или архивирован, писать в него нельзя
+	ErrSlowDown        = "slow_down"        // сообщения отправляются слишком часто, см. retry_after_ms
+	ErrDuplicate       = "duplicate"        // тот же текст уже отправлен недавно
+	ErrInternal        = "internal"         // ошибка на стороне сервера
+)
+
+// Envelope - кадр WebSocket. ID задаёт клиент; ответы сервера на кадр (ack, error, history)
+// возвращают тот же ID, чтобы клиент сопоставил их с запросом.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ErrorPayload - payload кадра error
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RetryAfterMs - через сколько миллисекунд можно повторить кадр (для slow_down и duplicate)
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
+//go:embed schema.json
+var schema []byte
+
+// Schema - JSON Schema кадров протокола, по которой клиенты могут проверять сообщения
+func Schema() []byte {
+	return schema
+}
+
+// Encode - собирает и сериализует кадр
+func Encode(frameType, id string, payload interface{}) ([]byte, error) {
+	env := Envelope{Type: frameType, ID: id}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сериализации payload кадра %s: %w", frameType, err)
+		}
+		env.Payload = raw
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации кадра %s: %w", frameType, err)
+	}
+	return data, nil
+}
+
+// EncodeError - кадр error в ответ на кадр клиента id
+func EncodeError(id, code, message string) []byte {
+	data, _ := Encode(TypeError, id, ErrorPayload{Code: code, Message: message})
+	return data
+}
+
+// HasType - быстрая проверка типа кадра, собранного Encode, без разбора всего JSON:
+// Encode всегда пишет type первым полем
+func HasType(data []byte, frameType string) bool {
+	return bytes.HasPrefix(data, []byte(`{"type":"`+frameType+`"`))
+}
+
+// Decode - разбирает кадр клиента
+func Decode(data []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("неверный JSON кадра: %w", err)
+	}
+	if env.Type == "" {
+		return nil, fmt.Errorf("в кадре не указан type")
+	}
+	if len(env.ID) > maxIDLength {
+		return nil, fmt.Errorf("id кадра длиннее %d символов", maxIDLength)
+	}
+	return &env, nil
+}
+
+// DecodePayload - разбирает payload кадра в v
+func (e *Envelope) DecodePayload(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("в кадре %s нет payload", e.Type)
+	}
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("неверный payload кадра %s: %w", e.Type, err)
+	}
+	return nil
+}
+
+// Supported - проверяет заголовок Sec-WebSocket-Protocol: если клиент перечислил версии
+// протокола, среди них должна быть поддерживаемая. Клиенты без версии получают текущую.
+func Supported(header string) bool {
+	versioned := false
+	for _, p := range strings.Split(header, ",") {
+		p = strings.TrimSpace(p)
+		if p == Subprotocol {
+			return true
+		}
+		if strings.HasPrefix(p, subprotocolPrefix) {
+			versioned = true
+		}
+	}
+	return !versioned
+}
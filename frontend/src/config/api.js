@@ -5,6 +5,7 @@ const MATCHMAKING_URL = import.meta.env.MATCHMAKING_URL || 'http://localhost/api
 const CHAT_LIST_URL = import.meta.env.CHAT_LIST_URL || 'http://localhost/api/chat/all'
 const CHAT_HISTORY_URL = import.meta.env.CHAT_HISTORY_URL || 'http://localhost/api/chat/history'
 const WS_CHAT_URL = import.meta.env.WS_CHAT_URL || 'ws://localhost/ws/chat'
+const CHAT_SETTINGS_URL = import.meta.env.CHAT_SETTINGS_URL || 'http://localhost/api/chat/settings'
 export const getRegisterUrl = () => {
   return REGISTER_URL
 }
@@ -33,6 +34,10 @@ export const getWsChatUrl = () => {
   return WS_CHAT_URL
 }
 
+export const getChatSettingsUrl = () => {
+  return CHAT_SETTINGS_URL
+}
+
 export const API_ENDPOINTS = {
   register: 'localhost/api/auth/register'
 }
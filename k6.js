@@ -12,28 +12,39 @@ export const options = {
     },
 };
 
+// Подключение требует JWT и участия в чате:
+// k6 run -e TOKEN=<jwt> -e CHAT_IDS=<public_id>,<public_id> k6.js
+// Все VU работают от одного пользователя и IP, поэтому для теста нужно поднять лимиты
+// соединений (CHAT_WS_MAX_CONNS_PER_USER, CHAT_WS_MAX_CONNS_PER_IP) и частоты сообщений
+// (PUT /api/chat/admin/rate-limits), иначе часть сообщений получит slow_down.
+const token = __ENV.TOKEN;
+const chatIds = (__ENV.CHAT_IDS || '').split(',').filter(Boolean);
+
 export default function () {
-    const chatId = Math.floor(Math.random() * 15000) + 1;
-    const timestamp = new Date().toISOString();
+    const chatId = chatIds[Math.floor(Math.random() * chatIds.length)];
 
     const url = `ws://localhost/ws/chat/${chatId}`;
 
-    const res = ws.connect(url, {}, function (socket) {
+    const res = ws.connect(url, { headers: { Authorization: `Bearer ${token}`, 'Sec-WebSocket-Protocol': 'chat.v1' } }, function (socket) {
         socket.on('open', function () {
             console.log(`WebSocket opened for chatId ${chatId}`);
             const payload = JSON.stringify({
-                senderId: userId,
-                content: `Test message ${Math.random().toString(36).substring(7)}`,
-                timestamp: timestamp,
+                type: 'message',
+                id: '1',
+                payload: { content: `Test message ${Math.random().toString(36).substring(7)}`, client_id: `${__VU}-${__ITER}` },
             });
             socket.send(payload);
         });
 
         socket.on('message', function (data) {
-            const msg = JSON.parse(data);
-            console.log(`Message received for chatId ${chatId}: ${JSON.stringify(msg)}`);
-            check(msg, {
-                'message received': () => msg.content && msg.sender_id,
+            const frame = JSON.parse(data);
+            console.log(`Frame received for chatId ${chatId}: ${JSON.stringify(frame)}`);
+            // Сначала приходит служебный кадр welcome, ждём рассылку своего сообщения
+            if (frame.type !== 'message') {
+                return;
+            }
+            check(frame, {
+                'message received': () => frame.payload.content && frame.payload.sender_id,
             });
             socket.close();
         });
@@ -1,15 +1,19 @@
 package app
 
 import (
+	"context"
 	"google.golang.org/grpc/credentials/insecure"
 	"log"
 	"os"
+	"time"
 
+	"matchmaking-service/internal/config"
 	"matchmaking-service/internal/grpc/chatpb"
 	"matchmaking-service/internal/handler"
 	"matchmaking-service/internal/repository"
 	"matchmaking-service/internal/service"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
@@ -44,12 +48,52 @@ func NewApp() *App {
 	}
 	chatClient := chatpb.NewChatServiceClient(chatConn)
 
-	matchmakingService := service.NewMatchmakingService(redisRepo, chatClient)
+	searchCfg, err := config.LoadSearchConfig()
+	if err != nil {
+		log.Fatalf("❌ Ошибка конфигурации поиска: %v", err)
+	}
+	log.Printf("⚙️ Поиск: таймаут %v (макс. %v), политика %q", searchCfg.Timeout, searchCfg.MaxTimeout, searchCfg.Policy)
+
+	reputationCfg, err := config.LoadReputationConfig()
+	if err != nil {
+		log.Fatalf("❌ Ошибка конфигурации репутации: %v", err)
+	}
+
+	reputationService := service.NewReputationService(redisRepo, chatClient, reputationCfg)
+	matchmakingService := service.NewMatchmakingService(redisRepo, chatClient, searchCfg, reputationService)
+
+	// 🔹 Публикуем статистику очереди для дашбордов
+	go matchmakingService.RunStatsPublisher(context.Background(), 5*time.Second)
+	// 🔹 Доставляем ожидающим на этом экземпляре чаты, подобранные на других, и возвращаем
+	// их в очередь, если матч сорвался
+	go matchmakingService.RunNoticeListener(context.Background())
+	// 🔹 Учитываем в репутации чаты, завершённые в chat-service (выход, архивация)
+	go reputationService.RunChatEndedListener(context.Background())
 
 	app := fiber.New()
 	matchmakingHandler := handler.NewMatchmakingHandler(matchmakingService)
+	reputationHandler := handler.NewReputationHandler(reputationService)
 
 	app.Get("api/matchmaking/start", matchmakingHandler.StartMatchmaking)
+	app.Get("api/matchmaking/status", matchmakingHandler.QueueStatus)
+	app.Post("api/matchmaking/skip", matchmakingHandler.SkipChat)
+	app.Post("api/matchmaking/reconnect", matchmakingHandler.RequestReconnect)
+	app.Get("api/matchmaking/reconnect/:chat_id", matchmakingHandler.ReconnectStatus)
+	app.Post("api/matchmaking/block", matchmakingHandler.BlockUser)
+	app.Post("api/matchmaking/report", reputationHandler.Report)
+
+	admin := app.Group("api/matchmaking/admin", handler.RequireAdminToken(reputationCfg.AdminToken))
+	admin.Get("/reputation/:user_id", reputationHandler.GetReputation)
+	admin.Put("/reputation/:user_id", reputationHandler.SetReputation)
+	admin.Get("/stats", matchmakingHandler.QueueStats)
+
+	app.Use("/ws/matchmaking", func(c *fiber.Ctx) error {
+		if websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+	app.Get("/ws/matchmaking/search", websocket.New(matchmakingHandler.SearchWebSocket))
 
 	return &App{
 		FiberApp: app,
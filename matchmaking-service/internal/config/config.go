@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeoutPolicy - что делать с пользователем, которого не подобрали за отведённое время
+type TimeoutPolicy string
+
+const (
+	PolicyGiveUp      TimeoutPolicy = "give_up"      // убрать из очереди и сообщить о таймауте
+	PolicyLowPriority TimeoutPolicy = "low_priority" // перевести в низкоприоритетный пул и ждать дальше
+	PolicyBot         TimeoutPolicy = "bot"          // создать чат с ботом-собеседником
+)
+
+// SearchConfig - настройки поиска собеседника
+type SearchConfig struct {
+	// Timeout - время поиска по умолчанию
+	Timeout time.Duration
+	// MaxTimeout - максимальное время поиска, которое может запросить клиент
+	MaxTimeout time.Duration
+	// Policy - поведение по истечении времени поиска
+	Policy TimeoutPolicy
+	// LowPriorityTimeout - сколько ещё ждать в низкоприоритетном пуле
+	LowPriorityTimeout time.Duration
+	// MaxRoomSize - максимальное число участников групповой комнаты
+	MaxRoomSize int
+	// ReconnectWindow - сколько действует согласие участника прошлого чата на повторный чат
+	ReconnectWindow time.Duration
+	// BotName - бот chat-service, которого получает пользователь при политике bot
+	BotName string
+}
+
+// LoadSearchConfig - читает настройки поиска из переменных окружения
+func LoadSearchConfig() (SearchConfig, error) {
+	cfg := SearchConfig{
+		Timeout:            30 * time.Second,
+		MaxTimeout:         2 * time.Minute,
+		Policy:             PolicyGiveUp,
+		LowPriorityTimeout: 2 * time.Minute,
+		MaxRoomSize:        5,
+		ReconnectWindow:    10 * time.Minute,
+		BotName:            "rules",
+	}
+
+	durations := map[string]*time.Duration{
+		"MATCHMAKING_SEARCH_TIMEOUT":       &cfg.Timeout,
+		"MATCHMAKING_MAX_SEARCH_TIMEOUT":   &cfg.MaxTimeout,
+		"MATCHMAKING_LOW_PRIORITY_TIMEOUT": &cfg.LowPriorityTimeout,
+		"MATCHMAKING_RECONNECT_WINDOW":     &cfg.ReconnectWindow,
+	}
+	for env, dst := range durations {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается длительность, например 30s", env, value)
+		}
+		*dst = d
+	}
+
+	if policy := os.Getenv("MATCHMAKING_TIMEOUT_POLICY"); policy != "" {
+		cfg.Policy = TimeoutPolicy(policy)
+	}
+	switch cfg.Policy {
+	case PolicyGiveUp, PolicyLowPriority, PolicyBot:
+	default:
+		return cfg, fmt.Errorf("неизвестная политика таймаута MATCHMAKING_TIMEOUT_POLICY=%q", cfg.Policy)
+	}
+
+	if bot := os.Getenv("MATCHMAKING_BOT_NAME"); bot != "" {
+		cfg.BotName = bot
+	}
+
+	if value := os.Getenv("MATCHMAKING_MAX_ROOM_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 2 {
+			return cfg, fmt.Errorf("неверное значение MATCHMAKING_MAX_ROOM_SIZE=%q: ожидается число не меньше 2", value)
+		}
+		cfg.MaxRoomSize = size
+	}
+
+	if cfg.MaxTimeout < cfg.Timeout {
+		cfg.MaxTimeout = cfg.Timeout
+	}
+	return cfg, nil
+}
+
+// ClampTimeout - время поиска с учётом запроса клиента (0 - по умолчанию), но не больше MaxTimeout
+func (c SearchConfig) ClampTimeout(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return c.Timeout
+	}
+	if requested > c.MaxTimeout {
+		return c.MaxTimeout
+	}
+	return requested
+}
+
+// ReputationConfig - настройки репутации и разбиения очереди на уровни
+type ReputationConfig struct {
+	// Default, Min, Max - начальная репутация и её границы
+	Default, Min, Max int
+	// TierThresholds - границы уровней репутации по возрастанию: пользователи подбираются
+	// в основном внутри своего уровня
+	TierThresholds []int
+	// TierRelaxAfter - после такого ожидания в очереди уровень собеседника не учитывается
+	TierRelaxAfter time.Duration
+	// ReportPenalty - штраф за жалобу собеседника
+	ReportPenalty int
+	// QuickSkipWindow, QuickSkipPenalty - штраф тому, кого пропустили в первые секунды чата
+	QuickSkipWindow  time.Duration
+	QuickSkipPenalty int
+	// LongChatDuration, LongChatBonus - бонус участникам чата, продлившегося дольше LongChatDuration
+	LongChatDuration time.Duration
+	LongChatBonus    int
+	// AdminToken - токен админского API (заголовок X-Admin-Token); пустой - API отключено
+	AdminToken string
+}
+
+// LoadReputationConfig - читает настройки репутации из переменных окружения
+func LoadReputationConfig() (ReputationConfig, error) {
+	cfg := ReputationConfig{
+		Default:          100,
+		Min:              0,
+		Max:              100,
+		TierThresholds:   []int{40, 70},
+		TierRelaxAfter:   time.Minute,
+		ReportPenalty:    15,
+		QuickSkipWindow:  10 * time.Second,
+		QuickSkipPenalty: 3,
+		LongChatDuration: 5 * time.Minute,
+		LongChatBonus:    2,
+		AdminToken:       os.Getenv("ADMIN_TOKEN"),
+	}
+
+	durations := map[string]*time.Duration{
+		"REPUTATION_TIER_RELAX_AFTER":   &cfg.TierRelaxAfter,
+		"REPUTATION_QUICK_SKIP_WINDOW":  &cfg.QuickSkipWindow,
+		"REPUTATION_LONG_CHAT_DURATION": &cfg.LongChatDuration,
+	}
+	for env, dst := range durations {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается длительность, например 30s", env, value)
+		}
+		*dst = d
+	}
+
+	ints := map[string]*int{
+		"REPUTATION_REPORT_PENALTY":     &cfg.ReportPenalty,
+		"REPUTATION_QUICK_SKIP_PENALTY": &cfg.QuickSkipPenalty,
+		"REPUTATION_LONG_CHAT_BONUS":    &cfg.LongChatBonus,
+	}
+	for env, dst := range ints {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("неверное значение %s=%q: ожидается неотрицательное число", env, value)
+		}
+		*dst = n
+	}
+
+	if value := os.Getenv("REPUTATION_TIERS"); value != "" {
+		thresholds, err := parseThresholds(value, cfg.Min, cfg.Max)
+		if err != nil {
+			return cfg, fmt.Errorf("неверное значение REPUTATION_TIERS=%q: %w", value, err)
+		}
+		cfg.TierThresholds = thresholds
+	}
+	return cfg, nil
+}
+
+// Tier - уровень репутации: число порогов, которые score достиг
+func (c ReputationConfig) Tier(score int) int {
+	tier := 0
+	for _, threshold := range c.TierThresholds {
+		if score >= threshold {
+			tier++
+		}
+	}
+	return tier
+}
+
+// parseThresholds - разбирает список порогов вида "40,70"
+func parseThresholds(value string, min, max int) ([]int, error) {
+	var thresholds []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.New("ожидается список чисел через запятую")
+		}
+		if n <= min || n > max || (len(thresholds) > 0 && n <= thresholds[len(thresholds)-1]) {
+			return nil, fmt.Errorf("пороги должны возрастать в пределах (%d, %d]", min, max)
+		}
+		thresholds = append(thresholds, n)
+	}
+	return thresholds, nil
+}
@@ -2,14 +2,21 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"matchmaking-service/internal/service"
+	"matchmaking-service/pkg/model"
 )
 
+// statusPushInterval - как часто ожидающему по WebSocket отправляется его позиция в очереди
+const statusPushInterval = 2 * time.Second
+
 type MatchmakingHandler struct {
 	matchmakingService *service.MatchmakingService
 }
@@ -18,33 +25,249 @@ func NewMatchmakingHandler(matchmakingService *service.MatchmakingService) *Matc
 	return &MatchmakingHandler{matchmakingService: matchmakingService}
 }
 
-// StartMatchmaking - обработчик запроса на поиск собеседника
-func (h *MatchmakingHandler) StartMatchmaking(c *fiber.Ctx) error {
-	// 1) Авторизация
+// userIDFromRequest - читает X-User-ID, выставленный шлюзом после проверки JWT.
+// При ошибке сам отправляет ответ клиенту и возвращает false.
+func userIDFromRequest(c *fiber.Ctx) (int64, bool) {
 	userIDStr := c.Get("X-User-ID")
 	if userIDStr == "" {
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Необходимо передать X-User-ID"})
+		c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Необходимо передать X-User-ID"})
+		return 0, false
 	}
 	userID, err := strconv.ParseInt(userIDStr, 10, 64)
 	if err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат X-User-ID"})
+		c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат X-User-ID"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// StartMatchmaking - обработчик запроса на поиск собеседника
+func (h *MatchmakingHandler) StartMatchmaking(c *fiber.Ctx) error {
+	// 1) Авторизация
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
 	}
 
 	log.Printf("🔍 Пользователь %d встал в очередь...", userID)
 
-	matchCh, err := h.matchmakingService.FindMatch(context.Background(), userID)
+	matchCh, err := h.matchmakingService.FindMatch(context.Background(), userID,
+		c.QueryInt("room_size"), requestedTimeout(c.QueryInt("timeout")))
+
+	// 2) Ждём результата поиска
+	return awaitMatch(c, matchCh, err)
+}
+
+// SkipChat - завершает текущий чат и сразу ищет нового собеседника
+func (h *MatchmakingHandler) SkipChat(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		ChatID string `json:"chat_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.ChatID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+
+	matchCh, err := h.matchmakingService.Skip(context.Background(), userID, req.ChatID,
+		c.QueryInt("room_size"), requestedTimeout(c.QueryInt("timeout")))
+	return awaitMatch(c, matchCh, err)
+}
+
+// awaitMatch - отвечает на long polling запрос финальным событием поиска
+// (промежуточные события в long polling не нужны)
+func awaitMatch(c *fiber.Ctx, matchCh <-chan model.MatchResult, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidRoomSize):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrChatNotFound):
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var result model.MatchResult
+	for result = range matchCh {
+	}
+	return c.JSON(matchResponse(result))
+}
+
+// requestedTimeout - время поиска, запрошенное клиентом в секундах (0 - по умолчанию)
+func requestedTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// matchResponse - ответ клиенту на событие поиска
+func matchResponse(result model.MatchResult) fiber.Map {
+	resp := fiber.Map{"event": result.Outcome}
+	switch result.Outcome {
+	case model.OutcomeMatch:
+		resp["data"] = result.ChatID
+	case model.OutcomeBotMatch:
+		resp["data"] = result.ChatID
+		resp["message"] = "Сейчас нет свободных собеседников, с вами пообщается бот"
+	case model.OutcomeTimeout:
+		resp["message"] = "Собеседник не найден за отведённое время"
+	case model.OutcomeLowPriority:
+		resp["message"] = "Поиск затянулся, продолжаем искать собеседника"
+	case model.OutcomeCancelled:
+		resp["message"] = "Поиск отменён"
+	case model.OutcomeRequeued:
+		resp["message"] = "Не удалось создать чат, продолжаем поиск с начала очереди"
+	}
+	return resp
+}
+
+// SearchWebSocket - поиск собеседника через WebSocket: пока пользователь ждёт,
+// ему периодически отправляется позиция в очереди и оценка времени ожидания
+func (h *MatchmakingHandler) SearchWebSocket(c *websocket.Conn) {
+	defer c.Close()
+
+	userID, err := strconv.ParseInt(c.Headers("X-User-ID"), 10, 64)
+	if err != nil {
+		c.WriteJSON(fiber.Map{"event": "error", "data": "Необходимо передать X-User-ID"})
+		return
+	}
+
+	// Отключение клиента отменяет поиск и убирает его из очереди
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	log.Printf("🔍 Пользователь %d встал в очередь (WebSocket)...", userID)
+
+	roomSize, _ := strconv.Atoi(c.Query("room_size"))
+	timeout, _ := strconv.Atoi(c.Query("timeout"))
+	matchCh, err := h.matchmakingService.FindMatch(ctx, userID, roomSize, requestedTimeout(timeout))
 	if err != nil {
+		c.WriteJSON(fiber.Map{"event": "error", "data": err.Error()})
+		return
+	}
+
+	ticker := time.NewTicker(statusPushInterval)
+	defer ticker.Stop()
+
+	for {
+		if status, err := h.matchmakingService.QueueStatus(ctx, userID); err == nil && status.InQueue {
+			if err := c.WriteJSON(fiber.Map{"event": "queue_status", "data": status}); err != nil {
+				return
+			}
+		}
+
+		select {
+		case result, ok := <-matchCh:
+			if !ok {
+				return
+			}
+			if err := c.WriteJSON(matchResponse(result)); err != nil || result.Final() {
+				return
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// RequestReconnect - согласие пообщаться снова с собеседниками завершённого чата
+func (h *MatchmakingHandler) RequestReconnect(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		ChatID string `json:"chat_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.ChatID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+
+	status, err := h.matchmakingService.RequestReconnect(context.Background(), userID, req.ChatID)
+	return reconnectResponse(c, status, err)
+}
+
+// ReconnectStatus - состояние запроса на повторный чат
+func (h *MatchmakingHandler) ReconnectStatus(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+	status, err := h.matchmakingService.ReconnectStatus(context.Background(), userID, c.Params("chat_id"))
+	return reconnectResponse(c, status, err)
+}
+
+func reconnectResponse(c *fiber.Ctx, status *model.ReconnectStatus, err error) error {
+	switch {
+	case errors.Is(err, service.ErrChatNotFound):
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrChatActive):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNotReconnectable):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
+	return c.JSON(status)
+}
 
-	// 2) Ждём появления chatID в канале
-	chatID, ok := <-matchCh
+// QueueStatus - позиция пользователя в очереди и оценка времени ожидания
+func (h *MatchmakingHandler) QueueStatus(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
 	if !ok {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "канал закрыт без совпадения"})
+		return nil
+	}
+
+	status, err := h.matchmakingService.QueueStatus(context.Background(), userID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(status)
+}
+
+// QueueStats - агрегированная статистика очереди для мониторинга (админское API)
+func (h *MatchmakingHandler) QueueStats(c *fiber.Ctx) error {
+	stats, err := h.matchmakingService.QueueStats(context.Background())
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(stats)
+}
+
+// BlockUser - обработчик запроса на блокировку собеседников из чата (по публичному ID)
+func (h *MatchmakingHandler) BlockUser(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		ChatID string `json:"chat_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.ChatID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+
+	err := h.matchmakingService.BlockUser(context.Background(), userID, req.ChatID)
+	switch {
+	case errors.Is(err, service.ErrChatNotFound):
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNoPartners):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(fiber.Map{
-		"event": "match",
-		"data":  chatID,
-	})
+	return c.JSON(fiber.Map{"message": "Собеседник заблокирован"})
 }
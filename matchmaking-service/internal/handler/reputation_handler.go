@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"matchmaking-service/internal/service"
+)
+
+type ReputationHandler struct {
+	reputationService *service.ReputationService
+}
+
+func NewReputationHandler(reputationService *service.ReputationService) *ReputationHandler {
+	return &ReputationHandler{reputationService: reputationService}
+}
+
+// RequireAdminToken - пропускает запрос, только если X-Admin-Token совпадает с token.
+// С пустым token админское API отключено.
+func RequireAdminToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token == "" {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Админское API отключено"})
+		}
+		if subtle.ConstantTimeCompare([]byte(c.Get("X-Admin-Token")), []byte(token)) != 1 {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "Неверный X-Admin-Token"})
+		}
+		return c.Next()
+	}
+}
+
+// Report - жалоба на собеседников из чата (по публичному ID)
+func (h *ReputationHandler) Report(c *fiber.Ctx) error {
+	userID, ok := userIDFromRequest(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		ChatID string `json:"chat_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.ChatID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+
+	err := h.reputationService.Report(context.Background(), userID, req.ChatID)
+	switch {
+	case errors.Is(err, service.ErrChatNotFound):
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNoPartners):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Жалоба принята"})
+}
+
+// GetReputation - репутация пользователя (админское API)
+func (h *ReputationHandler) GetReputation(c *fiber.Ctx) error {
+	userID, err := strconv.ParseInt(c.Params("user_id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный user_id"})
+	}
+
+	reputation, err := h.reputationService.Get(context.Background(), userID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(reputation)
+}
+
+// SetReputation - ручная установка репутации пользователя (админское API)
+func (h *ReputationHandler) SetReputation(c *fiber.Ctx) error {
+	userID, err := strconv.ParseInt(c.Params("user_id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный user_id"})
+	}
+
+	var req struct {
+		Score *int `json:"score"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Score == nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Неверный формат запроса"})
+	}
+
+	reputation, err := h.reputationService.Set(context.Background(), userID, *req.Score)
+	switch {
+	case errors.Is(err, service.ErrInvalidScore):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(reputation)
+}
@@ -2,13 +2,38 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	queueKey         = "matchmaking:queue"   // ZSET: user_id -> время постановки в очередь (мс), чаты 1:1
+	lowPoolSuffix    = ":low"                // суффикс низкоприоритетного пула очереди
+	ticketKeyPrefix  = "matchmaking:ticket:" // HASH: тикет пользователя (queue - текущий пул, main/low - пулы его очереди)
+	recentKeyPrefix  = "matchmaking:recent:"
+	blockedKeyPrefix = "matchmaking:blocked:"
+	reconnectPrefix  = "matchmaking:reconnect:" // ZSET: участники прошлого чата, согласные пообщаться снова -> время согласия, мс
+	reputationKey    = "matchmaking:reputation" // HASH: user_id -> репутация
+	reportedPrefix   = "matchmaking:reported:"  // STRING: жалоба reporter -> reported уже учтена
+	ratedPrefix      = "matchmaking:rated:"     // STRING: завершённый чат уже учтён в репутации
+	matchesKey       = "matchmaking:matches"    // ZSET: недавние матчи чатов 1:1 ("chat_id:забрано ожидающих" -> время, мс)
+	statsChannel     = "matchmaking:stats"      // pub/sub канал со статистикой очереди
+	requeueChannel   = "matchmaking:requeue"    // pub/sub канал просьб вернуть пользователя в очередь ("user_id:размер комнаты")
+	matchChannel     = "matchmaking:match"      // pub/sub канал результатов подбора ("user_id:публичный ID чата")
+	chatEndedChannel = "chat:ended"             // pub/sub канал chat-service с завершёнными чатами (JSON ChatEnded)
+	matchScanLimit   = 200                      // сколько кандидатов из головы очереди просматривает Lua-скрипт
+)
+
+// ErrAlreadyInQueue - пользователь уже стоит в очереди
+var ErrAlreadyInQueue = errors.New("пользователь уже находится в очереди")
+
 type RedisRepository struct {
 	client *redis.Client
 }
@@ -17,79 +42,811 @@ func NewRedisRepository(client *redis.Client) *RedisRepository {
 	return &RedisRepository{client: client}
 }
 
-func (r *RedisRepository) AddUserToQueue(ctx context.Context, userID int64) error {
-	err := r.client.RPush(ctx, "matchmaking_queue", strconv.FormatInt(userID, 10)).Err()
+func ticketKey(userID string) string {
+	return ticketKeyPrefix + userID
+}
+
+// queueKeys - основная очередь и низкоприоритетный пул для комнат указанного размера.
+// Чаты 1:1 живут в matchmaking:queue, групповые комнаты - в matchmaking:queue:<размер>.
+func queueKeys(roomSize int) (string, string) {
+	main := queueKey
+	if roomSize > 2 {
+		main = queueKey + ":" + strconv.Itoa(roomSize)
+	}
+	return main, main + lowPoolSuffix
+}
+
+// roomSizeOfQueue - размер комнат очереди по ключу её основного пула (обратное к queueKeys)
+func roomSizeOfQueue(main string) int {
+	if size, err := strconv.Atoi(strings.TrimPrefix(main, queueKey+":")); err == nil {
+		return size
+	}
+	return 2
+}
+
+// matchesKeyFor - журнал матчей очереди комнат указанного размера
+func matchesKeyFor(roomSize int) string {
+	if roomSize > 2 {
+		return matchesKey + ":" + strconv.Itoa(roomSize)
+	}
+	return matchesKey
+}
+
+// Lua-скрипт постановки в очередь: тикет и запись в ZSET создаются атомарно,
+// повторная постановка того же пользователя невозможна.
+const enqueueScript = `
+local queue_key = KEYS[1]
+local low_key = KEYS[2]
+local ticket_key = KEYS[3]
+local user_id = ARGV[1]
+local enqueued_at = ARGV[2]
+if redis.call('EXISTS', ticket_key) == 1 then
+    return 0
+end
+redis.call('HSET', ticket_key, 'queue', queue_key, 'main', queue_key, 'low', low_key, 'enqueued_at', enqueued_at)
+redis.call('ZADD', queue_key, enqueued_at, user_id)
+return 1
+`
+
+// AddUserToQueue - атомарно ставит пользователя в очередь комнат указанного размера
+func (r *RedisRepository) AddUserToQueue(ctx context.Context, userID int64, roomSize int) error {
+	id := strconv.FormatInt(userID, 10)
+	main, low := queueKeys(roomSize)
+	added, err := r.client.Eval(ctx, enqueueScript, []string{main, low, ticketKey(id)},
+		id, time.Now().UnixMilli(),
+	).Int()
 	if err != nil {
 		return fmt.Errorf("ошибка добавления в очередь: %w", err)
 	}
+	if added == 0 {
+		return ErrAlreadyInQueue
+	}
 	log.Printf("🔹 Пользователь %d добавлен в очередь", userID)
 	return nil
 }
 
+// Lua-скрипт удаления из очереди: удаляет тикет и запись в той очереди, на которую он указывает
+const dequeueScript = `
+local ticket_key = KEYS[1]
+local user_id = ARGV[1]
+local queue_key = redis.call('HGET', ticket_key, 'queue')
+if not queue_key then
+    return 0
+end
+redis.call('ZREM', queue_key, user_id)
+redis.call('DEL', ticket_key)
+return 1
+`
+
+// RemoveUserFromQueue - атомарно удаляет пользователя из очереди
 func (r *RedisRepository) RemoveUserFromQueue(ctx context.Context, userID int64) error {
-	// Удаляем пользователя из очереди
-	err := r.client.LRem(ctx, "matchmaking_queue", 0, strconv.FormatInt(userID, 10)).Err()
+	_, err := r.TakeFromQueue(ctx, userID)
+	return err
+}
+
+// TakeFromQueue - атомарно удаляет пользователя из очереди; false, если его там уже не было
+// (например, его только что забрал подбор собеседника)
+func (r *RedisRepository) TakeFromQueue(ctx context.Context, userID int64) (bool, error) {
+	id := strconv.FormatInt(userID, 10)
+	removed, err := r.client.Eval(ctx, dequeueScript, []string{ticketKey(id)}, id).Int()
 	if err != nil {
-		return fmt.Errorf("ошибка удаления из очереди: %w", err)
+		return false, fmt.Errorf("ошибка удаления из очереди: %w", err)
 	}
-	log.Printf("🔹 Пользователь %d удален из очереди", userID)
+	if removed == 1 {
+		log.Printf("🔹 Пользователь %d удален из очереди", userID)
+	}
+	return removed == 1, nil
+}
+
+// Lua-скрипт возврата пользователя в голову очереди (перед текущим первым ожидающим)
+const requeueAtHeadScript = `
+local queue_key = KEYS[1]
+local low_key = KEYS[2]
+local ticket_key = KEYS[3]
+local user_id = ARGV[1]
+local now = ARGV[2]
+if redis.call('EXISTS', ticket_key) == 1 then
+    return 0
+end
+local score = tonumber(now)
+local head = redis.call('ZRANGE', queue_key, 0, 0, 'WITHSCORES')
+if head[2] and tonumber(head[2]) <= score then
+    score = tonumber(head[2]) - 1
+end
+redis.call('HSET', ticket_key, 'queue', queue_key, 'main', queue_key, 'low', low_key, 'enqueued_at', now)
+redis.call('ZADD', queue_key, score, user_id)
+return 1
+`
+
+// RequeueAtHead - атомарно возвращает пользователя в начало очереди (например, после неудачного матча)
+func (r *RedisRepository) RequeueAtHead(ctx context.Context, userID int64, roomSize int) error {
+	id := strconv.FormatInt(userID, 10)
+	main, low := queueKeys(roomSize)
+	added, err := r.client.Eval(ctx, requeueAtHeadScript, []string{main, low, ticketKey(id)},
+		id, time.Now().UnixMilli(),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("ошибка возврата в очередь: %w", err)
+	}
+	if added == 0 {
+		return ErrAlreadyInQueue
+	}
+	log.Printf("↩️ Пользователь %d возвращён в начало очереди", userID)
 	return nil
 }
 
+// IsUserInQueue - проверяет наличие тикета пользователя за O(1)
 func (r *RedisRepository) IsUserInQueue(ctx context.Context, userID int64) (bool, error) {
-	// Проверяем, есть ли пользователь в очереди
-	queue, err := r.client.LRange(ctx, "matchmaking_queue", 0, -1).Result()
+	exists, err := r.client.Exists(ctx, ticketKey(strconv.FormatInt(userID, 10))).Result()
 	if err != nil {
 		return false, fmt.Errorf("ошибка проверки очереди: %w", err)
 	}
-	userIDStr := strconv.FormatInt(userID, 10)
-	for _, id := range queue {
-		if id == userIDStr {
-			return true, nil
+	return exists == 1, nil
+}
+
+// QueueLength - количество ожидающих комнаты указанного размера (вместе с низкоприоритетным пулом)
+func (r *RedisRepository) QueueLength(ctx context.Context, roomSize int) (int64, error) {
+	main, low := queueKeys(roomSize)
+	pipe := r.client.Pipeline()
+	mainLen := pipe.ZCard(ctx, main)
+	lowLen := pipe.ZCard(ctx, low)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("ошибка получения длины очереди: %w", err)
+	}
+	return mainLen.Val() + lowLen.Val(), nil
+}
+
+// Lua-скрипт перевода пользователя в низкоприоритетный пул с сохранением времени постановки
+const moveToLowPriorityScript = `
+local ticket_key = KEYS[1]
+local user_id = ARGV[1]
+local queue_key = redis.call('HGET', ticket_key, 'queue')
+if not queue_key then
+    return 0
+end
+local low_key = redis.call('HGET', ticket_key, 'low')
+if queue_key ~= low_key then
+    local score = redis.call('ZSCORE', queue_key, user_id)
+    redis.call('ZREM', queue_key, user_id)
+    redis.call('ZADD', low_key, score, user_id)
+    redis.call('HSET', ticket_key, 'queue', low_key)
+end
+return 1
+`
+
+// MoveToLowPriority - переводит ожидающего в низкоприоритетный пул; false, если пользователя уже нет в очереди
+func (r *RedisRepository) MoveToLowPriority(ctx context.Context, userID int64) (bool, error) {
+	id := strconv.FormatInt(userID, 10)
+	moved, err := r.client.Eval(ctx, moveToLowPriorityScript, []string{ticketKey(id)}, id).Int()
+	if err != nil {
+		return false, fmt.Errorf("ошибка перевода в низкоприоритетный пул: %w", err)
+	}
+	if moved == 1 {
+		log.Printf("🔸 Пользователь %d переведён в низкоприоритетный пул", userID)
+	}
+	return moved == 1, nil
+}
+
+// AddRecentPartners - запоминает участников одного чата как недавних собеседников друг друга
+func (r *RedisRepository) AddRecentPartners(ctx context.Context, userIDs []int64, window time.Duration) error {
+	now := time.Now()
+	minScore := strconv.FormatInt(now.Add(-window).Unix(), 10)
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range userIDs {
+			key := recentKeyPrefix + strconv.FormatInt(userID, 10)
+			for _, partnerID := range userIDs {
+				if partnerID == userID {
+					continue
+				}
+				pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Unix()), Member: strconv.FormatInt(partnerID, 10)})
+			}
+			pipe.ZRemRangeByScore(ctx, key, "-inf", "("+minScore)
+			pipe.Expire(ctx, key, window)
 		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения недавних собеседников: %w", err)
+	}
+	return nil
+}
+
+// BlockUser - добавляет пользователя в блок-лист
+func (r *RedisRepository) BlockUser(ctx context.Context, userID, blockedID int64) error {
+	key := blockedKeyPrefix + strconv.FormatInt(userID, 10)
+	if err := r.client.SAdd(ctx, key, strconv.FormatInt(blockedID, 10)).Err(); err != nil {
+		return fmt.Errorf("ошибка блокировки пользователя: %w", err)
 	}
-	return false, nil
+	log.Printf("🚫 Пользователь %d заблокировал пользователя %d", userID, blockedID)
+	return nil
 }
 
-// Lua-скрипт для атомарного получения и удаления пользователя из очереди
-const getMatchingUserScript = `
+// ReputationTiers - параметры разбиения очереди на уровни репутации при подборе
+type ReputationTiers struct {
+	Default    int           // репутация пользователя без оценок
+	Thresholds []int         // границы уровней по возрастанию
+	RelaxAfter time.Duration // после такого ожидания уровень собеседника не учитывается
+}
+
+// Lua-скрипт подбора собеседников. Атомарно:
+//   - отказывает, если у пользователя уже есть тикет (-1);
+//   - набирает из головы очереди (а затем низкоприоритетного пула) нужное число кандидатов,
+//     совместимых с пользователем и друг с другом: без блокировок (в обе стороны) и недавних
+//     встреч, из одного уровня репутации (если никто из пары не ждёт дольше relax_ms);
+//     забирает их тикеты и возвращает список;
+//   - если кандидатов не хватает, ставит пользователя в очередь (0).
+const matchOrEnqueueScript = `
 local queue_key = KEYS[1]
+local low_key = KEYS[2]
 local user_id = ARGV[1]
-local user = redis.call('LPOP', queue_key)
-if user == false then
-    return 0
+local recent_min = tonumber(ARGV[2])
+local scan_limit = tonumber(ARGV[3])
+local now = ARGV[4]
+local needed = tonumber(ARGV[5])
+local ticket_prefix = ARGV[6]
+local recent_prefix = ARGV[7]
+local blocked_prefix = ARGV[8]
+local reputation_key = ARGV[9]
+local default_score = tonumber(ARGV[10])
+local relax_ms = tonumber(ARGV[11])
+local thresholds = {}
+for i = 12, #ARGV do
+    table.insert(thresholds, tonumber(ARGV[i]))
+end
+
+local own_ticket = ticket_prefix .. user_id
+if redis.call('EXISTS', own_ticket) == 1 then
+    return -1
+end
+
+local function compatible(a, b)
+    if a == b
+        or redis.call('SISMEMBER', blocked_prefix .. a, b) == 1
+        or redis.call('SISMEMBER', blocked_prefix .. b, a) == 1 then
+        return false
+    end
+    local seen = redis.call('ZSCORE', recent_prefix .. a, b)
+    return not seen or tonumber(seen) < recent_min
 end
-if user == user_id then
-    local next_user = redis.call('LPOP', queue_key)
-    if next_user then
-        redis.call('RPUSH', queue_key, user)
+
+local function tier(u)
+    local score = tonumber(redis.call('HGET', reputation_key, u)) or default_score
+    local t = 0
+    for _, threshold in ipairs(thresholds) do
+        if score >= threshold then
+            t = t + 1
+        end
     end
-    if next_user == false then
-        return 0
+    return t
+end
+
+-- Уровень репутации и время ожидания (мс) участников группы и кандидатов
+local tiers = {[user_id] = tier(user_id)}
+local waited = {[user_id] = 0}
+local function same_tier(a, b)
+    return tiers[a] == tiers[b] or waited[a] >= relax_ms or waited[b] >= relax_ms
+end
+
+local group = {user_id}
+local picked = {}
+-- Сначала основная очередь, затем низкоприоритетный пул
+for _, pool_key in ipairs({queue_key, low_key}) do
+    local candidates = redis.call('ZRANGE', pool_key, 0, scan_limit - 1, 'WITHSCORES')
+    for i = 1, #candidates, 2 do
+        local candidate = candidates[i]
+        tiers[candidate] = tier(candidate)
+        waited[candidate] = tonumber(now) - tonumber(candidates[i + 1])
+        local fits = true
+        for _, member in ipairs(group) do
+            if not compatible(member, candidate) or not same_tier(member, candidate) then
+                fits = false
+                break
+            end
+        end
+        if fits then
+            table.insert(group, candidate)
+            table.insert(picked, {candidate, pool_key})
+            if #picked == needed then
+                local result = {}
+                for _, p in ipairs(picked) do
+                    redis.call('ZREM', p[2], p[1])
+                    redis.call('DEL', ticket_prefix .. p[1])
+                    table.insert(result, p[1])
+                end
+                return result
+            end
+        end
     end
-    return next_user
 end
-return user
+
+redis.call('HSET', own_ticket, 'queue', queue_key, 'main', queue_key, 'low', low_key, 'enqueued_at', now)
+redis.call('ZADD', queue_key, now, user_id)
+return 0
 `
 
-func (r *RedisRepository) GetMatchingUser(ctx context.Context, userID int64) (int64, error) {
-	// Используем Lua-скрипт для атомарного получения пользователя
-	result, err := r.client.Eval(ctx, getMatchingUserScript, []string{"matchmaking_queue"}, strconv.FormatInt(userID, 10)).Result()
+// MatchOrEnqueue - атомарно подбирает roomSize-1 собеседников из очереди, а если их не хватает -
+// ставит пользователя в очередь. Возвращает ID собеседников или nil, если пользователь встал в очередь.
+func (r *RedisRepository) MatchOrEnqueue(ctx context.Context, userID int64, roomSize int, recentWindow time.Duration, tiers ReputationTiers) ([]int64, error) {
+	main, low := queueKeys(roomSize)
+	args := []interface{}{
+		strconv.FormatInt(userID, 10),
+		time.Now().Add(-recentWindow).Unix(),
+		matchScanLimit,
+		time.Now().UnixMilli(),
+		roomSize - 1,
+		ticketKeyPrefix,
+		recentKeyPrefix,
+		blockedKeyPrefix,
+		reputationKey,
+		tiers.Default,
+		tiers.RelaxAfter.Milliseconds(),
+	}
+	for _, threshold := range tiers.Thresholds {
+		args = append(args, threshold)
+	}
+	result, err := r.client.Eval(ctx, matchOrEnqueueScript, []string{main, low}, args...).Result()
 	if err != nil {
-		return 0, fmt.Errorf("ошибка выполнения Lua-скрипта: %w", err)
+		return nil, fmt.Errorf("ошибка выполнения Lua-скрипта: %w", err)
 	}
 
 	switch v := result.(type) {
 	case int64:
-		if v == 0 {
-			return 0, nil
+		if v == -1 {
+			return nil, ErrAlreadyInQueue
 		}
-		return v, nil
-	case string:
-		matchUserID, _ := strconv.ParseInt(v, 10, 64)
-		log.Printf("✅ Найден пользователь из очереди: %d", matchUserID)
-		return matchUserID, nil
+		log.Printf("🔹 Пользователь %d добавлен в очередь", userID)
+		return nil, nil
+	case []interface{}:
+		partnerIDs := make([]int64, 0, len(v))
+		for _, raw := range v {
+			s, _ := raw.(string)
+			partnerID, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("неверный ID пользователя в очереди %q: %w", s, err)
+			}
+			partnerIDs = append(partnerIDs, partnerID)
+		}
+		log.Printf("✅ Найдены пользователи из очереди: %v", partnerIDs)
+		return partnerIDs, nil
 	default:
-		return 0, fmt.Errorf("неожиданный тип результата Lua-скрипта: %T", v)
+		return nil, fmt.Errorf("неожиданный тип результата Lua-скрипта: %T", v)
+	}
+}
+
+// Lua-скрипт получения позиции пользователя в его очереди. Ожидающие в низкоприоритетном
+// пуле стоят после всей основной очереди. Возвращает {позиция с нуля, длина очереди, ключ основного пула} или -1.
+const queuePositionScript = `
+local ticket_key = KEYS[1]
+local user_id = ARGV[1]
+local queue_key = redis.call('HGET', ticket_key, 'queue')
+if not queue_key then
+    return -1
+end
+local main_key = redis.call('HGET', ticket_key, 'main')
+local low_key = redis.call('HGET', ticket_key, 'low')
+local rank = redis.call('ZRANK', queue_key, user_id)
+if not rank then
+    return -1
+end
+if queue_key == low_key then
+    rank = rank + redis.call('ZCARD', main_key)
+end
+return {rank, redis.call('ZCARD', main_key) + redis.call('ZCARD', low_key), main_key}
+`
+
+// QueuePosition - позиция пользователя (с нуля), длина его очереди и размер комнат, которые
+// в ней подбираются; false, если пользователь не в очереди
+func (r *RedisRepository) QueuePosition(ctx context.Context, userID int64) (int64, int64, int, bool, error) {
+	id := strconv.FormatInt(userID, 10)
+	result, err := r.client.Eval(ctx, queuePositionScript, []string{ticketKey(id)}, id).Result()
+	if err != nil {
+		return 0, 0, 0, false, fmt.Errorf("ошибка получения позиции в очереди: %w", err)
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 3 {
+		return 0, 0, 0, false, nil
+	}
+	rank, _ := values[0].(int64)
+	length, _ := values[1].(int64)
+	main, _ := values[2].(string)
+	return rank, length, roomSizeOfQueue(main), true, nil
+}
+
+// OldestEnqueuedAt - время постановки в очередь самого давнего ожидающего комнаты указанного размера
+func (r *RedisRepository) OldestEnqueuedAt(ctx context.Context, roomSize int) (time.Time, bool, error) {
+	var oldest time.Time
+	found := false
+	main, low := queueKeys(roomSize)
+	for _, key := range []string{main, low} {
+		head, err := r.client.ZRangeWithScores(ctx, key, 0, 0).Result()
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("ошибка чтения очереди: %w", err)
+		}
+		if len(head) == 0 {
+			continue
+		}
+		if t := time.UnixMilli(int64(head[0].Score)); !found || t.Before(oldest) {
+			oldest, found = t, true
+		}
+	}
+	return oldest, found, nil
+}
+
+// RecordMatch - отмечает состоявшийся матч в очереди комнат roomSize для расчёта темпа подбора;
+// consumed - сколько ожидающих матч забрал из очереди
+func (r *RedisRepository) RecordMatch(ctx context.Context, chatID int64, roomSize, consumed int, window time.Duration) error {
+	key := matchesKeyFor(roomSize)
+	now := time.Now()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: fmt.Sprintf("%d:%d", chatID, consumed)})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения статистики матчей: %w", err)
+	}
+	return nil
+}
+
+// MatchesSince - сколько матчей состоялось в очереди комнат roomSize начиная с момента since
+// и сколько ожидающих они забрали из очереди
+func (r *RedisRepository) MatchesSince(ctx context.Context, roomSize int, since time.Time) (int64, int64, error) {
+	members, err := r.client.ZRangeByScore(ctx, matchesKeyFor(roomSize), &redis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка чтения статистики матчей: %w", err)
+	}
+	var consumed int64
+	for _, member := range members {
+		_, n, _ := strings.Cut(member, ":")
+		if waiters, err := strconv.ParseInt(n, 10, 64); err == nil {
+			consumed += waiters
+		}
+	}
+	return int64(len(members)), consumed, nil
+}
+
+// PublishStats - публикует агрегированную статистику очереди для дашбордов
+func (r *RedisRepository) PublishStats(ctx context.Context, payload []byte) error {
+	if err := r.client.Publish(ctx, statsChannel, payload).Err(); err != nil {
+		return fmt.Errorf("ошибка публикации статистики: %w", err)
+	}
+	return nil
+}
+
+// RequeueNotice - просьба вернуть ожидающего пользователя в начало очереди после неудачного матча
+type RequeueNotice struct {
+	UserID   int64
+	RoomSize int
+}
+
+// PublishRequeue - рассылает просьбу вернуть пользователя в очередь всем экземплярам сервиса:
+// вернуть его может только тот, на котором он ждёт результата поиска
+func (r *RedisRepository) PublishRequeue(ctx context.Context, notice RequeueNotice) error {
+	payload := fmt.Sprintf("%d:%d", notice.UserID, notice.RoomSize)
+	if err := r.client.Publish(ctx, requeueChannel, payload).Err(); err != nil {
+		return fmt.Errorf("ошибка публикации возврата в очередь: %w", err)
+	}
+	return nil
+}
+
+// SubscribeRequeue - просьбы вернуть пользователя в очередь от всех экземпляров сервиса.
+// Канал закрывается после отмены ctx.
+func (r *RedisRepository) SubscribeRequeue(ctx context.Context) <-chan RequeueNotice {
+	notices := make(chan RequeueNotice)
+	go func() {
+		defer close(notices)
+		for payload := range r.subscribe(ctx, requeueChannel) {
+			userID, roomSize, _ := strings.Cut(payload, ":")
+			var notice RequeueNotice
+			var err error
+			if notice.UserID, err = strconv.ParseInt(userID, 10, 64); err == nil {
+				notice.RoomSize, err = strconv.Atoi(roomSize)
+			}
+			if err != nil {
+				log.Printf("⚠️ Неверная просьба вернуть в очередь %q", payload)
+				continue
+			}
+			select {
+			case notices <- notice:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return notices
+}
+
+// MatchNotice - пользователю подобран чат на другом экземпляре сервиса
+type MatchNotice struct {
+	UserID int64
+	ChatID string // публичный ID чата
+}
+
+// PublishMatch - рассылает результат подбора всем экземплярам сервиса: доставить его может
+// только тот, на котором пользователь ждёт результата поиска
+func (r *RedisRepository) PublishMatch(ctx context.Context, notice MatchNotice) error {
+	payload := fmt.Sprintf("%d:%s", notice.UserID, notice.ChatID)
+	if err := r.client.Publish(ctx, matchChannel, payload).Err(); err != nil {
+		return fmt.Errorf("ошибка публикации результата подбора: %w", err)
 	}
+	return nil
+}
+
+// SubscribeMatches - результаты подбора от всех экземпляров сервиса.
+// Канал закрывается после отмены ctx.
+func (r *RedisRepository) SubscribeMatches(ctx context.Context) <-chan MatchNotice {
+	notices := make(chan MatchNotice)
+	go func() {
+		defer close(notices)
+		for payload := range r.subscribe(ctx, matchChannel) {
+			userID, chatID, _ := strings.Cut(payload, ":")
+			id, err := strconv.ParseInt(userID, 10, 64)
+			if err != nil || chatID == "" {
+				log.Printf("⚠️ Неверный результат подбора %q", payload)
+				continue
+			}
+			select {
+			case notices <- MatchNotice{UserID: id, ChatID: chatID}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return notices
+}
+
+// subscribe - сообщения pub/sub канала; канал закрывается после отмены ctx
+func (r *RedisRepository) subscribe(ctx context.Context, channel string) <-chan string {
+	pubsub := r.client.Subscribe(ctx, channel)
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case payloads <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return payloads
+}
+
+func reconnectKey(chatID int64) string {
+	return reconnectPrefix + strconv.FormatInt(chatID, 10)
+}
+
+func reconnectResultKey(chatID int64) string {
+	return reconnectKey(chatID) + ":result"
+}
+
+func reconnectRoundKey(chatID int64) string {
+	return reconnectKey(chatID) + ":round"
+}
+
+// Lua-скрипт согласия на повторный чат. Окно у каждого участника своё и отсчитывается от его
+// согласия: время согласия хранится как score в ZSET, так что по сроку своего согласия нельзя
+// узнать о чужом. Раунд - момент (мс) первого согласия, когда действующих согласий не было;
+// он отличает повторные чаты одних и тех же участников.
+// Возвращает {1, раунд}, когда согласились все участники и никто никого не заблокировал, иначе {0, раунд}.
+const optInReconnectScript = `
+local optin_key = KEYS[1]
+local round_key = KEYS[2]
+local user_id = ARGV[1]
+local window_ms = tonumber(ARGV[2])
+local blocked_prefix = ARGV[3]
+local now = tonumber(ARGV[4])
+if redis.call('TYPE', optin_key).ok ~= 'zset' then
+    redis.call('DEL', optin_key)
+end
+redis.call('ZREMRANGEBYSCORE', optin_key, '-inf', now - window_ms)
+if redis.call('ZCARD', optin_key) == 0 then
+    redis.call('SET', round_key, now)
+else
+    redis.call('SET', round_key, now, 'NX')
+end
+redis.call('ZADD', optin_key, 'NX', now, user_id)
+redis.call('PEXPIRE', optin_key, window_ms)
+redis.call('PEXPIRE', round_key, window_ms)
+local round = redis.call('GET', round_key)
+for i = 5, #ARGV do
+    if not redis.call('ZSCORE', optin_key, ARGV[i]) then
+        return {0, round}
+    end
+    for j = 5, #ARGV do
+        if i ~= j and redis.call('SISMEMBER', blocked_prefix .. ARGV[i], ARGV[j]) == 1 then
+            return {0, round}
+        end
+    end
+end
+return {1, round}
+`
+
+// OptInReconnect - фиксирует согласие участника прошлого чата пообщаться снова.
+// Возвращает true, если теперь согласны все участники, и раунд согласий: он одинаков
+// для всех участников, пока не истечёт окно или не будет создан повторный чат.
+func (r *RedisRepository) OptInReconnect(ctx context.Context, chatID, userID int64, memberIDs []int64, window time.Duration) (bool, string, error) {
+	args := []interface{}{strconv.FormatInt(userID, 10), window.Milliseconds(), blockedKeyPrefix, time.Now().UnixMilli()}
+	for _, memberID := range memberIDs {
+		args = append(args, strconv.FormatInt(memberID, 10))
+	}
+	result, err := r.client.Eval(ctx, optInReconnectScript, []string{reconnectKey(chatID), reconnectRoundKey(chatID)}, args...).Slice()
+	if err != nil {
+		return false, "", fmt.Errorf("ошибка сохранения запроса на повторный чат: %w", err)
+	}
+	if len(result) != 2 {
+		return false, "", fmt.Errorf("неожиданный результат Lua-скрипта: %v", result)
+	}
+	complete, _ := result[0].(int64)
+	round, _ := result[1].(string)
+	return complete == 1, round, nil
+}
+
+// SaveReconnectResult - сохраняет созданный повторный чат и снимает согласия
+func (r *RedisRepository) SaveReconnectResult(ctx context.Context, chatID int64, newChatID string, ttl time.Duration) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, reconnectResultKey(chatID), newChatID, ttl)
+		pipe.Del(ctx, reconnectKey(chatID), reconnectRoundKey(chatID))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения повторного чата: %w", err)
+	}
+	return nil
+}
+
+// GetReconnectState - состояние запроса на повторный чат с точки зрения пользователя:
+// публичный ID созданного чата (пусто, если его нет), дал ли пользователь согласие и сколько
+// ещё действует его собственное согласие (окно window от момента согласия)
+func (r *RedisRepository) GetReconnectState(ctx context.Context, chatID, userID int64, window time.Duration) (string, bool, time.Duration, error) {
+	pipe := r.client.Pipeline()
+	result := pipe.Get(ctx, reconnectResultKey(chatID))
+	optedAt := pipe.ZScore(ctx, reconnectKey(chatID), strconv.FormatInt(userID, 10))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return "", false, 0, fmt.Errorf("ошибка чтения запроса на повторный чат: %w", err)
+	}
+	if optedAt.Err() != nil {
+		return result.Val(), false, 0, nil
+	}
+	left := time.Until(time.UnixMilli(int64(optedAt.Val())).Add(window))
+	if left <= 0 {
+		return result.Val(), false, 0, nil
+	}
+	return result.Val(), true, left, nil
+}
+
+// GetReputation - репутация пользователя; defaultScore, если оценок ещё не было
+func (r *RedisRepository) GetReputation(ctx context.Context, userID int64, defaultScore int) (int, error) {
+	score, err := r.client.HGet(ctx, reputationKey, strconv.FormatInt(userID, 10)).Int()
+	if errors.Is(err, redis.Nil) {
+		return defaultScore, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка чтения репутации: %w", err)
+	}
+	return score, nil
+}
+
+// SetReputation - устанавливает репутацию пользователя
+func (r *RedisRepository) SetReputation(ctx context.Context, userID int64, score int) error {
+	if err := r.client.HSet(ctx, reputationKey, strconv.FormatInt(userID, 10), score).Err(); err != nil {
+		return fmt.Errorf("ошибка сохранения репутации: %w", err)
+	}
+	return nil
+}
+
+// Lua-скрипт изменения репутации на delta в пределах [min, max]
+const adjustReputationScript = `
+local score = tonumber(redis.call('HGET', KEYS[1], ARGV[1])) or tonumber(ARGV[3])
+score = math.max(tonumber(ARGV[4]), math.min(tonumber(ARGV[5]), score + tonumber(ARGV[2])))
+redis.call('HSET', KEYS[1], ARGV[1], score)
+return score
+`
+
+// AdjustReputation - атомарно меняет репутацию на delta, не выходя за [min, max]. Возвращает новое значение.
+func (r *RedisRepository) AdjustReputation(ctx context.Context, userID int64, delta, defaultScore, min, max int) (int, error) {
+	score, err := r.client.Eval(ctx, adjustReputationScript, []string{reputationKey},
+		strconv.FormatInt(userID, 10), delta, defaultScore, min, max,
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка изменения репутации: %w", err)
+	}
+	return score, nil
+}
+
+// Lua-скрипт разового изменения репутации: отметка KEYS[2] и изменение репутации всех
+// пользователей из ARGV[6:] на delta в пределах [min, max] выполняются вместе, поэтому
+// отметка не может остаться без изменения репутации. Возвращает 0, если отметка уже была.
+const markAndAdjustScript = `
+if not redis.call('SET', KEYS[2], 1, 'NX', 'PX', ARGV[1]) then
+    return 0
+end
+for i = 6, #ARGV do
+    local score = tonumber(redis.call('HGET', KEYS[1], ARGV[i])) or tonumber(ARGV[3])
+    score = math.max(tonumber(ARGV[4]), math.min(tonumber(ARGV[5]), score + tonumber(ARGV[2])))
+    redis.call('HSET', KEYS[1], ARGV[i], score)
+end
+return 1
+`
+
+// markAndAdjust - атомарно ставит отметку key на ttl и меняет репутацию userIDs на delta;
+// false, если отметка уже стояла и репутация не менялась
+func (r *RedisRepository) markAndAdjust(ctx context.Context, key string, ttl time.Duration, userIDs []int64, delta, defaultScore, min, max int) (bool, error) {
+	args := []interface{}{ttl.Milliseconds(), delta, defaultScore, min, max}
+	for _, userID := range userIDs {
+		args = append(args, strconv.FormatInt(userID, 10))
+	}
+	applied, err := r.client.Eval(ctx, markAndAdjustScript, []string{reputationKey, key}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return applied == 1, nil
+}
+
+// ApplyReport - учитывает жалобу reporterID на reportedID, снижая репутацию на penalty;
+// false, если такая жалоба уже учтена за ttl
+func (r *RedisRepository) ApplyReport(ctx context.Context, reporterID, reportedID int64, penalty, defaultScore, min, max int, ttl time.Duration) (bool, error) {
+	key := reportedPrefix + strconv.FormatInt(reporterID, 10) + ":" + strconv.FormatInt(reportedID, 10)
+	applied, err := r.markAndAdjust(ctx, key, ttl, []int64{reportedID}, -penalty, defaultScore, min, max)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения жалобы: %w", err)
+	}
+	return applied, nil
+}
+
+// ApplyChatRating - меняет репутацию участников завершённого чата на delta; false, если
+// чат уже учтён за ttl
+func (r *RedisRepository) ApplyChatRating(ctx context.Context, chatID int64, userIDs []int64, delta, defaultScore, min, max int, ttl time.Duration) (bool, error) {
+	applied, err := r.markAndAdjust(ctx, ratedPrefix+strconv.FormatInt(chatID, 10), ttl, userIDs, delta, defaultScore, min, max)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения оценки чата: %w", err)
+	}
+	return applied, nil
+}
+
+// ChatEnded - событие chat-service о завершении чата
+type ChatEnded struct {
+	ChatID    int64     `json:"chat_id"`
+	MemberIDs []int64   `json:"member_ids"`
+	CreatedAt time.Time `json:"created_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	// EndedBy - кто завершил чат; 0, если чат заброшен и архивирован по сроку
+	EndedBy int64  `json:"ended_by,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// SubscribeChatEnded - события о завершении чатов из chat-service. Каждое событие получают
+// все экземпляры сервиса. Канал закрывается после отмены ctx.
+func (r *RedisRepository) SubscribeChatEnded(ctx context.Context) <-chan ChatEnded {
+	events := make(chan ChatEnded)
+	go func() {
+		defer close(events)
+		for payload := range r.subscribe(ctx, chatEndedChannel) {
+			var event ChatEnded
+			if err := json.Unmarshal([]byte(payload), &event); err != nil {
+				log.Printf("⚠️ Неверное событие о завершении чата %q: %v", payload, err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
 }
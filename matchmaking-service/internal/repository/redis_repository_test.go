@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// seededQueueSize - сколько ожидающих стоит в очереди перед замером
+const seededQueueSize = 100000
+
+var benchTiers = ReputationTiers{Default: 100, Thresholds: []int{40, 70}, RelaxAfter: time.Minute}
+
+// newTestClient - клиент Redis для тестов. По умолчанию используется miniredis; чтобы
+// замерить настоящий Redis, укажите BENCH_REDIS_ADDR (база 15 будет очищена).
+func newTestClient(tb testing.TB) *redis.Client {
+	tb.Helper()
+	if addr := os.Getenv("BENCH_REDIS_ADDR"); addr != "" {
+		client := redis.NewClient(&redis.Options{Addr: addr, DB: 15})
+		if err := client.FlushDB(context.Background()).Err(); err != nil {
+			tb.Fatalf("❌ Ошибка подключения к Redis: %v", err)
+		}
+		tb.Cleanup(func() {
+			client.FlushDB(context.Background())
+			client.Close()
+		})
+		return client
+	}
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(tb).Addr()})
+	tb.Cleanup(func() { client.Close() })
+	return client
+}
+
+// seedQueue - ставит пользователей [1, n] в очередь 1:1 одним пайплайном; score задаёт
+// репутацию всех ожидающих
+func seedQueue(tb testing.TB, client *redis.Client, n int64, score int) {
+	tb.Helper()
+	ctx := context.Background()
+	main, low := queueKeys(2)
+	// Все встали в очередь за последнюю секунду: ожидание меньше RelaxAfter,
+	// а новички из замера встают в хвост
+	since := float64(time.Now().Add(-time.Second).UnixMilli())
+	pipe := client.Pipeline()
+	for id := int64(1); id <= n; id++ {
+		uid := strconv.FormatInt(id, 10)
+		enqueuedAt := since + float64(id)*float64(time.Second.Milliseconds())/float64(n+1)
+		pipe.HSet(ctx, ticketKey(uid), "queue", main, "main", main, "low", low, "enqueued_at", int64(enqueuedAt))
+		pipe.ZAdd(ctx, main, redis.Z{Score: enqueuedAt, Member: uid})
+		pipe.HSet(ctx, reputationKey, uid, score)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		tb.Fatalf("❌ Ошибка заполнения очереди: %v", err)
+	}
+}
+
+func TestMatchOrEnqueue(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRedisRepository(newTestClient(t))
+
+	partners, err := repo.MatchOrEnqueue(ctx, 1, 2, time.Hour, benchTiers)
+	if err != nil || partners != nil {
+		t.Fatalf("первый пользователь должен встать в очередь: %v, %v", partners, err)
+	}
+	if _, err := repo.MatchOrEnqueue(ctx, 1, 2, time.Hour, benchTiers); err != ErrAlreadyInQueue {
+		t.Fatalf("повторная постановка: ожидалась ErrAlreadyInQueue, получено %v", err)
+	}
+
+	if err := repo.BlockUser(ctx, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	partners, err = repo.MatchOrEnqueue(ctx, 2, 2, time.Hour, benchTiers)
+	if err != nil || partners != nil {
+		t.Fatalf("заблокировавший не должен попасть в пару: %v, %v", partners, err)
+	}
+
+	partners, err = repo.MatchOrEnqueue(ctx, 3, 2, time.Hour, benchTiers)
+	if err != nil || len(partners) != 1 || partners[0] != 1 {
+		t.Fatalf("ожидалась пара с пользователем 1: %v, %v", partners, err)
+	}
+	if inQueue, _ := repo.IsUserInQueue(ctx, 1); inQueue {
+		t.Fatal("подобранный пользователь остался в очереди")
+	}
+	if length, _ := repo.QueueLength(ctx, 2); length != 1 {
+		t.Fatalf("в очереди должен остаться только пользователь 2, длина %d", length)
+	}
+}
+
+// BenchmarkMatchOrEnqueuePair - новичок сразу находит пару в голове очереди из 100k ожидающих;
+// после каждого подбора очередь пополняется, чтобы её длина не менялась
+func BenchmarkMatchOrEnqueuePair(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ctx := context.Background()
+	client := newTestClient(b)
+	repo := NewRedisRepository(client)
+	seedQueue(b, client, seededQueueSize, benchTiers.Default)
+
+	next := int64(seededQueueSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		next++
+		partners, err := repo.MatchOrEnqueue(ctx, next, 2, 15*time.Minute, benchTiers)
+		if err != nil || len(partners) != 1 {
+			b.Fatalf("ожидалась пара: %v, %v", partners, err)
+		}
+		b.StopTimer()
+		next++
+		if err := repo.AddUserToQueue(ctx, next, 2); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+// BenchmarkMatchOrEnqueueNoMatch - худший случай: все ожидающие из другого уровня репутации,
+// скрипт просматривает matchScanLimit кандидатов и ставит новичка в очередь
+func BenchmarkMatchOrEnqueueNoMatch(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ctx := context.Background()
+	client := newTestClient(b)
+	repo := NewRedisRepository(client)
+	seedQueue(b, client, seededQueueSize, 10)
+
+	next := int64(seededQueueSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		next++
+		partners, err := repo.MatchOrEnqueue(ctx, next, 2, 15*time.Minute, benchTiers)
+		if err != nil || partners != nil {
+			b.Fatalf("новичок не должен найти пару: %v, %v", partners, err)
+		}
+	}
+}
+
+func TestMatchesSinceCountsConsumedWaiters(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRedisRepository(newTestClient(t))
+
+	if err := repo.RecordMatch(ctx, 1, 2, 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for chatID := int64(2); chatID <= 3; chatID++ {
+		if err := repo.RecordMatch(ctx, chatID, 4, 3, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	since := time.Now().Add(-time.Minute)
+	if matches, consumed, err := repo.MatchesSince(ctx, 2, since); err != nil || matches != 1 || consumed != 1 {
+		t.Fatalf("очередь 1:1: матчей %d, ожидающих %d, %v", matches, consumed, err)
+	}
+	if matches, consumed, err := repo.MatchesSince(ctx, 4, since); err != nil || matches != 2 || consumed != 6 {
+		t.Fatalf("комнаты на 4: матчей %d, ожидающих %d, %v", matches, consumed, err)
+	}
+}
+
+func TestQueuePositionRoomSize(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRedisRepository(newTestClient(t))
+
+	for _, id := range []int64{1, 2} {
+		if err := repo.AddUserToQueue(ctx, id, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rank, length, roomSize, inQueue, err := repo.QueuePosition(ctx, 2)
+	if err != nil || !inQueue || rank != 1 || length != 2 || roomSize != 3 {
+		t.Fatalf("позиция %d из %d, комнаты на %d, в очереди %v, %v", rank, length, roomSize, inQueue, err)
+	}
+}
+
+func TestOptInReconnectRounds(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRedisRepository(newTestClient(t))
+	members := []int64{1, 2}
+
+	complete, first, err := repo.OptInReconnect(ctx, 10, 1, members, time.Minute)
+	if err != nil || complete || first == "" {
+		t.Fatalf("первое согласие: %v, раунд %q, %v", complete, first, err)
+	}
+	complete, round, err := repo.OptInReconnect(ctx, 10, 2, members, time.Minute)
+	if err != nil || !complete || round != first {
+		t.Fatalf("второе согласие: %v, раунд %q (ожидался %q), %v", complete, round, first, err)
+	}
+	if err := repo.SaveReconnectResult(ctx, 10, "chat", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	_, next, err := repo.OptInReconnect(ctx, 10, 1, members, time.Minute)
+	if err != nil || next == "" || next == first {
+		t.Fatalf("новый раунд должен отличаться от %q: %q, %v", first, next, err)
+	}
+}
+
+// Срок согласия у каждого участника свой: по нему нельзя понять, что собеседник уже согласился
+func TestOptInReconnectWindowPerUser(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRedisRepository(newTestClient(t))
+	members := []int64{1, 2, 3}
+	const window = time.Minute
+
+	if _, _, err := repo.OptInReconnect(ctx, 10, 1, members, window); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	complete, _, err := repo.OptInReconnect(ctx, 10, 2, members, window)
+	if err != nil || complete {
+		t.Fatalf("второе согласие из трёх: %v, %v", complete, err)
+	}
+
+	_, optedIn, left, err := repo.GetReconnectState(ctx, 10, 2, window)
+	if err != nil || !optedIn || left <= window-50*time.Millisecond || left > window {
+		t.Fatalf("окно второго участника должно быть полным: %v, осталось %v, %v", optedIn, left, err)
+	}
+	_, optedIn, left, err = repo.GetReconnectState(ctx, 10, 1, window)
+	if err != nil || !optedIn || left > window-100*time.Millisecond {
+		t.Fatalf("окно первого участника отсчитывается от его согласия: %v, осталось %v, %v", optedIn, left, err)
+	}
+	if _, optedIn, _, err := repo.GetReconnectState(ctx, 10, 3, window); err != nil || optedIn {
+		t.Fatalf("третий участник не соглашался: %v, %v", optedIn, err)
+	}
+}
+
+func TestMatchNoticeReachesOtherInstance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newTestClient(t)
+	publisher := NewRedisRepository(client)
+	notices := NewRedisRepository(client).SubscribeMatches(ctx)
+
+	// Подписка оформляется асинхронно, поэтому публикуем, пока результат не дойдёт
+	want := MatchNotice{UserID: 7, ChatID: "c0ffee"}
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(time.Second)
+	for {
+		if err := publisher.PublishMatch(ctx, want); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-notices:
+			if got != want {
+				t.Fatalf("получен %+v, ожидался %+v", got, want)
+			}
+			return
+		case <-ticker.C:
+		case <-deadline:
+			t.Fatal("результат подбора не доставлен")
+		}
+	}
+}
+
+func TestApplyReportOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRedisRepository(newTestClient(t))
+
+	applied, err := repo.ApplyReport(ctx, 1, 2, 10, 100, 0, 100, time.Hour)
+	if err != nil || !applied {
+		t.Fatalf("первая жалоба: %v, %v", applied, err)
+	}
+	applied, err = repo.ApplyReport(ctx, 1, 2, 10, 100, 0, 100, time.Hour)
+	if err != nil || applied {
+		t.Fatalf("повторная жалоба не должна учитываться: %v, %v", applied, err)
+	}
+	if score, err := repo.GetReputation(ctx, 2, 100); err != nil || score != 90 {
+		t.Fatalf("репутация после жалобы %d, ожидалось 90, %v", score, err)
+	}
+}
+
+func TestApplyChatRatingOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := NewRedisRepository(newTestClient(t))
+
+	for i := 0; i < 2; i++ {
+		if _, err := repo.ApplyChatRating(ctx, 5, []int64{1, 2}, 3, 90, 0, 100, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []int64{1, 2} {
+		if score, err := repo.GetReputation(ctx, id, 90); err != nil || score != 93 {
+			t.Fatalf("репутация пользователя %d: %d, ожидалось 93 (чат учитывается один раз), %v", id, score, err)
+		}
+	}
+}
@@ -2,108 +2,620 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
+	"matchmaking-service/internal/config"
 	"matchmaking-service/internal/grpc/chatpb"
 	"matchmaking-service/internal/repository"
+	"matchmaking-service/pkg/model"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// recentPartnersWindow - сколько времени недавние собеседники не подбираются друг другу повторно
+	recentPartnersWindow = 15 * time.Minute
+	// matchRateWindow - окно, по которому считается темп подбора пар для оценки времени ожидания
+	matchRateWindow = 5 * time.Minute
+
+	// Повторы создания чата в chat-service
+	createChatAttempts       = 4
+	createChatTimeout        = 2 * time.Second
+	createChatInitialBackoff = 200 * time.Millisecond
+
+	// settleTimeout - сколько ждать результата подбора, забравшего тикет ожидающего: дольше,
+	// чем все попытки создания чата; settleInterval - как часто проверять тикет
+	settleTimeout  = 15 * time.Second
+	settleInterval = 200 * time.Millisecond
+)
+
+var (
+	// ErrInvalidRoomSize - запрошен недопустимый размер комнаты
+	ErrInvalidRoomSize = errors.New("недопустимый размер комнаты")
+	// ErrChatNotFound - чат не существует или пользователь в нём не участвует
+	ErrChatNotFound = errors.New("чат не найден")
+	// ErrChatActive - чат ещё не завершён
+	ErrChatActive = errors.New("чат ещё не завершён")
+	// ErrNotReconnectable - повторный чат возможен только между людьми, а не с ботом
+	ErrNotReconnectable = errors.New("повторный чат возможен только с собеседниками-людьми")
+	// ErrNoPartners - в чате нет других людей, кроме пользователя (например, чат с ботом)
+	ErrNoPartners = errors.New("в чате нет собеседников")
 )
 
+// skipReason - причина завершения чата при переходе к следующему собеседнику
+const skipReason = "skipped"
+
 type MatchmakingService struct {
 	redisRepo   *repository.RedisRepository
 	chatSvc     chatpb.ChatServiceClient
-	subscribers map[int64]chan int64
+	searchCfg   config.SearchConfig
+	reputation  *ReputationService
+	subscribers map[int64]chan model.MatchResult
 	mu          sync.Mutex
 }
 
 func NewMatchmakingService(
 	redisRepo *repository.RedisRepository,
 	chatSvc chatpb.ChatServiceClient,
+	searchCfg config.SearchConfig,
+	reputation *ReputationService,
 ) *MatchmakingService {
 	return &MatchmakingService{
 		redisRepo:   redisRepo,
 		chatSvc:     chatSvc,
-		subscribers: make(map[int64]chan int64),
+		searchCfg:   searchCfg,
+		reputation:  reputation,
+		subscribers: make(map[int64]chan model.MatchResult),
 	}
 }
 
-func (s *MatchmakingService) FindMatch(ctx context.Context, userID int64) (<-chan int64, error) {
-	// Проверяем, находится ли пользователь уже в очереди или чате
-	inQueue, err := s.redisRepo.IsUserInQueue(ctx, userID)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка проверки статуса пользователя: %w", err)
+// FindMatch - запускает поиск собеседника. В канал приходят события поиска; последнее
+// событие (MatchResult.Final) завершает поиск, после чего канал закрывается.
+// roomSize - число участников чата (2 - обычный чат, больше - групповая комната),
+// timeout - запрошенное клиентом время поиска (0 - по умолчанию), ограниченное конфигурацией.
+func (s *MatchmakingService) FindMatch(ctx context.Context, userID int64, roomSize int, timeout time.Duration) (<-chan model.MatchResult, error) {
+	if roomSize == 0 {
+		roomSize = 2
 	}
-	if inQueue {
-		return nil, fmt.Errorf("пользователь %d уже находится в очереди", userID)
+	if roomSize < 2 || roomSize > s.searchCfg.MaxRoomSize {
+		return nil, fmt.Errorf("%w: допустимо от 2 до %d участников", ErrInvalidRoomSize, s.searchCfg.MaxRoomSize)
 	}
-
-	ch := make(chan int64, 1)
+	timeout = s.searchCfg.ClampTimeout(timeout)
+	// Буфер на промежуточные события (возврат в очередь, низкоприоритетный пул) и финальное,
+	// чтобы отправка никогда не блокировалась
+	ch := make(chan model.MatchResult, 3)
 	s.mu.Lock()
+	if _, ok := s.subscribers[userID]; ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("пользователь %d уже находится в очереди", userID)
+	}
 	s.subscribers[userID] = ch
 	s.mu.Unlock()
 
-	// Проверяем, есть ли подходящий партнер
-	partnerID, err := s.redisRepo.GetMatchingUser(ctx, userID)
+	// Атомарно ищем подходящих собеседников или встаём в очередь
+	partnerIDs, err := s.redisRepo.MatchOrEnqueue(ctx, userID, roomSize, recentPartnersWindow, s.reputation.Tiers())
 	if err != nil {
+		s.mu.Lock()
+		delete(s.subscribers, userID)
+		s.mu.Unlock()
+		if errors.Is(err, repository.ErrAlreadyInQueue) {
+			return nil, fmt.Errorf("пользователь %d уже находится в очереди", userID)
+		}
 		return nil, fmt.Errorf("ошибка поиска партнера: %w", err)
 	}
 
-	if partnerID == 0 {
-		// Нет подходящего партнера, добавляем в очередь с таймаутом
-		if err := s.redisRepo.AddUserToQueue(ctx, userID); err != nil {
-			s.mu.Lock()
-			delete(s.subscribers, userID)
-			close(ch)
-			s.mu.Unlock()
-			return nil, fmt.Errorf("ошибка добавления в очередь: %w", err)
-		}
-
-		// Запускаем таймер для таймаута ожидания
-		go func() {
-			select {
-			case <-time.After(30 * time.Second): // Таймаут 30 секунд
-				s.mu.Lock()
-				if ch, ok := s.subscribers[userID]; ok {
-					close(ch)
-					delete(s.subscribers, userID)
-				}
-				s.mu.Unlock()
-				s.redisRepo.RemoveUserFromQueue(ctx, userID)
-			case <-ctx.Done():
-				s.mu.Lock()
-				if ch, ok := s.subscribers[userID]; ok {
-					close(ch)
-					delete(s.subscribers, userID)
-				}
-				s.mu.Unlock()
-				s.redisRepo.RemoveUserFromQueue(ctx, userID)
-			}
-		}()
+	if len(partnerIDs) == 0 {
+		// Нет подходящих собеседников - ждём с таймаутом
+		go s.waitForMatch(ctx, userID, timeout)
 		return ch, nil
 	}
+	memberIDs := append([]int64{userID}, partnerIDs...)
 
 	// Создаем чат через gRPC
-	resp, err := s.chatSvc.CreateChat(ctx, &chatpb.CreateChatRequest{
-		User1Id: userID,
-		User2Id: partnerID,
-	})
+	chat, err := s.createChat(ctx, memberIDs, uuid.NewString())
 	if err != nil {
-		return nil, fmt.Errorf("ошибка создания чата через gRPC: %w", err)
+		log.Printf("❌ Не удалось создать чат для %v: %v", memberIDs, err)
+		// С точки зрения пользователей матча не было: возвращаем всех в начало очереди
+		if err := s.requeueAfterFailedMatch(ctx, userID, partnerIDs, roomSize, timeout); err != nil {
+			return nil, fmt.Errorf("ошибка создания чата через gRPC: %w", err)
+		}
+		return ch, nil
 	}
-	chatID := resp.GetChatId()
 
-	// Уведомляем обоих пользователей
+	if err := s.redisRepo.AddRecentPartners(ctx, memberIDs, recentPartnersWindow); err != nil {
+		log.Printf("⚠️ Не удалось сохранить недавних собеседников %v: %v", memberIDs, err)
+	}
+	if err := s.redisRepo.RecordMatch(ctx, chat.GetChatId(), roomSize, len(partnerIDs), matchRateWindow); err != nil {
+		log.Printf("⚠️ Не удалось обновить статистику матчей: %v", err)
+	}
+
+	// Уведомляем всех участников: партнёры могут ждать результата на других экземплярах
+	result := model.MatchResult{Outcome: model.OutcomeMatch, ChatID: chat.GetPublicId()}
+	s.finishSearch(userID, result)
+	for _, partnerID := range partnerIDs {
+		if s.finishSearch(partnerID, result) {
+			continue
+		}
+		notice := repository.MatchNotice{UserID: partnerID, ChatID: chat.GetPublicId()}
+		if err := s.redisRepo.PublishMatch(context.Background(), notice); err != nil {
+			log.Printf("⚠️ Не удалось сообщить пользователю %d о чате: %v", partnerID, err)
+		}
+	}
+
+	return ch, nil
+}
+
+// finishSearch - отправляет подписчику финальное событие и закрывает его канал.
+// Если поиск пользователя уже завершён, ничего не делает.
+func (s *MatchmakingService) finishSearch(userID int64, result model.MatchResult) bool {
 	s.mu.Lock()
-	if otherCh, ok := s.subscribers[partnerID]; ok {
-		otherCh <- chatID
-		close(otherCh)
-		delete(s.subscribers, partnerID)
+	defer s.mu.Unlock()
+	ch, ok := s.subscribers[userID]
+	if !ok {
+		return false
 	}
-	ch <- chatID
+	ch <- result
 	close(ch)
 	delete(s.subscribers, userID)
-	s.mu.Unlock()
+	return true
+}
 
-	return ch, nil
+// notifySearch - отправляет подписчику промежуточное событие, не завершая поиск.
+// Последнее место в буфере всегда остаётся за финальным событием.
+func (s *MatchmakingService) notifySearch(userID int64, result model.MatchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.subscribers[userID]; ok && len(ch) < cap(ch)-1 {
+		ch <- result
+	}
+}
+
+// waitForMatch - ждёт, пока пользователя подберут. По истечении timeout применяет
+// политику из конфигурации, при отмене ctx убирает пользователя из очереди.
+func (s *MatchmakingService) waitForMatch(ctx context.Context, userID int64, timeout time.Duration) {
+	if !s.waitOrCancel(ctx, userID, timeout) {
+		return
+	}
+
+	switch s.searchCfg.Policy {
+	case config.PolicyLowPriority:
+		moved, err := s.redisRepo.MoveToLowPriority(context.Background(), userID)
+		if err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+		// Если тикета уже нет, пользователя забрал подбор - его результат дождётся settle
+		if moved {
+			s.notifySearch(userID, model.MatchResult{Outcome: model.OutcomeLowPriority})
+			if !s.waitOrCancel(ctx, userID, s.searchCfg.LowPriorityTimeout) {
+				return
+			}
+		}
+	case config.PolicyBot:
+		s.assignBot(userID)
+		return
+	}
+
+	s.settle(userID, model.OutcomeTimeout)
+}
+
+// settle - завершает поиск событием outcome, если тикет пользователя ещё в очереди.
+// Сначала забирается тикет, а не подписка: если его уже забрал параллельный подбор, чат
+// создаётся прямо сейчас и пользователь должен получить его, а не outcome. Тогда settle ждёт
+// результата подбора: при успехе его доставит finishSearch, при неудаче пользователь вернётся
+// в очередь и его тикет будет забран на следующей проверке.
+func (s *MatchmakingService) settle(userID int64, outcome model.MatchOutcome) {
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		taken, err := s.redisRepo.TakeFromQueue(context.Background(), userID)
+		if err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+		// Без ответа Redis или результата подбора поиск всё равно нужно завершить
+		if taken || err != nil || time.Now().After(deadline) {
+			s.finishSearch(userID, model.MatchResult{Outcome: outcome})
+			return
+		}
+		if !s.searching(userID) {
+			return
+		}
+		time.Sleep(settleInterval)
+	}
+}
+
+// searching - ждёт ли пользователь результата поиска на этом экземпляре
+func (s *MatchmakingService) searching(userID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.subscribers[userID]
+	return ok
+}
+
+// assignBot - забирает пользователя из очереди и создаёт ему чат с ботом.
+// Если пользователя уже забрал подбор собеседника, результат придёт оттуда.
+func (s *MatchmakingService) assignBot(userID int64) {
+	ctx := context.Background()
+	taken, err := s.redisRepo.TakeFromQueue(ctx, userID)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+	if !taken {
+		return
+	}
+
+	result := model.MatchResult{Outcome: model.OutcomeTimeout}
+	resp, err := s.chatSvc.CreateChat(ctx, &chatpb.CreateChatRequest{
+		UserIds:        []int64{userID},
+		IdempotencyKey: uuid.NewString(),
+		Bot:            s.searchCfg.BotName,
+	})
+	if err != nil {
+		log.Printf("❌ Не удалось создать чат с ботом для пользователя %d: %v", userID, err)
+	} else {
+		result = model.MatchResult{Outcome: model.OutcomeBotMatch, ChatID: resp.GetPublicId()}
+		log.Printf("🤖 Пользователю %d назначен бот %q (чат %d)", userID, s.searchCfg.BotName, resp.GetChatId())
+	}
+	s.finishSearch(userID, result)
+}
+
+// waitOrCancel - ждёт d; возвращает false, если поиск отменён через ctx (и уже завершён)
+func (s *MatchmakingService) waitOrCancel(ctx context.Context, userID int64, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		s.settle(userID, model.OutcomeCancelled)
+		return false
+	}
+}
+
+// createChat - создаёт чат через gRPC с повторами. Все попытки используют один ключ
+// идемпотентности, поэтому повтор после потерянного ответа не создаст второй чат.
+func (s *MatchmakingService) createChat(ctx context.Context, memberIDs []int64, idempotencyKey string) (*chatpb.CreateChatResponse, error) {
+	req := &chatpb.CreateChatRequest{
+		UserIds:        memberIDs,
+		IdempotencyKey: idempotencyKey,
+	}
+
+	backoff := createChatInitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= createChatAttempts; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, createChatTimeout)
+		resp, err := s.chatSvc.CreateChat(attemptCtx, req)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if !isRetryable(err) || attempt == createChatAttempts {
+			break
+		}
+
+		log.Printf("⚠️ Попытка %d создания чата не удалась: %v, повтор через %v", attempt, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+	return nil, lastErr
+}
+
+// isRetryable - можно ли повторить gRPC-вызов с тем же ключом идемпотентности
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// requeueAfterFailedMatch - возвращает всех участников в начало очереди после неудачного
+// создания чата. Партнёры могут ждать на других экземплярах, поэтому их возвращает тот
+// экземпляр, на котором они всё ещё ждут (см. RunNoticeListener).
+func (s *MatchmakingService) requeueAfterFailedMatch(ctx context.Context, userID int64, partnerIDs []int64, roomSize int, timeout time.Duration) error {
+	for _, partnerID := range partnerIDs {
+		// Партнёр не должен пострадать, даже если ctx инициатора уже отменён
+		notice := repository.RequeueNotice{UserID: partnerID, RoomSize: roomSize}
+		if err := s.redisRepo.PublishRequeue(context.Background(), notice); err != nil {
+			log.Printf("⚠️ Не удалось вернуть пользователя %d в очередь: %v", partnerID, err)
+		}
+	}
+
+	if err := s.redisRepo.RequeueAtHead(ctx, userID, roomSize); err != nil {
+		s.mu.Lock()
+		if ch, ok := s.subscribers[userID]; ok {
+			close(ch)
+			delete(s.subscribers, userID)
+		}
+		s.mu.Unlock()
+		return err
+	}
+	s.notifySearch(userID, model.MatchResult{Outcome: model.OutcomeRequeued})
+	go s.waitForMatch(ctx, userID, timeout)
+	return nil
+}
+
+// RunNoticeListener - обрабатывает события поиска с других экземпляров для пользователей,
+// которые ждут на этом: доставляет найденные для них чаты и возвращает их в начало
+// очереди, если их матч сорвался
+func (s *MatchmakingService) RunNoticeListener(ctx context.Context) {
+	matches := s.redisRepo.SubscribeMatches(ctx)
+	requeues := s.redisRepo.SubscribeRequeue(ctx)
+	for matches != nil || requeues != nil {
+		select {
+		case notice, ok := <-matches:
+			if !ok {
+				matches = nil
+				continue
+			}
+			s.finishSearch(notice.UserID, model.MatchResult{Outcome: model.OutcomeMatch, ChatID: notice.ChatID})
+		case notice, ok := <-requeues:
+			if !ok {
+				requeues = nil
+				continue
+			}
+			if !s.searching(notice.UserID) {
+				continue
+			}
+			if err := s.redisRepo.RequeueAtHead(ctx, notice.UserID, notice.RoomSize); err != nil {
+				log.Printf("⚠️ Не удалось вернуть пользователя %d в очередь: %v", notice.UserID, err)
+				continue
+			}
+			s.notifySearch(notice.UserID, model.MatchResult{Outcome: model.OutcomeRequeued})
+		}
+	}
+}
+
+// Skip - завершает текущий чат пользователя (по публичному ID) и сразу запускает новый поиск.
+// Собеседники из завершённого чата не будут подобраны повторно в течение recentPartnersWindow.
+func (s *MatchmakingService) Skip(ctx context.Context, userID int64, publicChatID string, roomSize int, timeout time.Duration) (<-chan model.MatchResult, error) {
+	chat, err := findUserChat(ctx, s.chatSvc, userID, publicChatID)
+	if err != nil {
+		return nil, err
+	}
+	chatID := chat.GetChatId()
+
+	resp, err := s.chatSvc.EndChat(ctx, &chatpb.EndChatRequest{
+		ChatId: chatID,
+		UserId: userID,
+		Reason: skipReason,
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrChatNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка завершения чата через gRPC: %w", err)
+	}
+
+	// Окно недавних собеседников отсчитывается заново с момента пропуска
+	if err := s.redisRepo.AddRecentPartners(ctx, resp.GetMemberIds(), recentPartnersWindow); err != nil {
+		log.Printf("⚠️ Не удалось исключить собеседников чата %d из поиска: %v", chatID, err)
+	}
+	// chat-service сообщит о завершении и через chat:ended, но пропуск учитываем сразу;
+	// чат учитывается в репутации один раз
+	ended, err := endedChatOf(chatID, userID, skipReason, resp)
+	if err == nil {
+		err = s.reputation.RateEndedChat(ctx, ended)
+	}
+	if err != nil {
+		log.Printf("⚠️ Не удалось учесть чат %d в репутации: %v", chatID, err)
+	}
+
+	log.Printf("⏭️ Пользователь %d пропустил чат %d и ищет нового собеседника", userID, chatID)
+	return s.FindMatch(ctx, userID, roomSize, timeout)
+}
+
+// RequestReconnect - согласие участника завершённого чата пообщаться с теми же собеседниками снова.
+// Новый чат создаётся, только когда в течение ReconnectWindow согласятся все участники;
+// до этого никто не узнаёт о чужом согласии.
+func (s *MatchmakingService) RequestReconnect(ctx context.Context, userID int64, publicChatID string) (*model.ReconnectStatus, error) {
+	chat, err := findUserChat(ctx, s.chatSvc, userID, publicChatID)
+	if err != nil {
+		return nil, err
+	}
+	// Заброшенный чат архивируется без завершения, но тоже больше не идёт
+	if chat.GetEndedAt() == "" && chat.GetState() != "archived" {
+		return nil, ErrChatActive
+	}
+	// Согласие в чате с ботом или без собеседника не с кем разделить
+	if chat.GetBot() != "" || len(chat.GetMemberIds()) < 2 {
+		return nil, ErrNotReconnectable
+	}
+	chatID := chat.GetChatId()
+
+	complete, round, err := s.redisRepo.OptInReconnect(ctx, chatID, userID, chat.GetMemberIds(), s.searchCfg.ReconnectWindow)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		return s.ReconnectStatus(ctx, userID, publicChatID)
+	}
+
+	// Ключ идемпотентности общий для всех участников раунда: если они согласились одновременно,
+	// будет создан один чат, а следующий раунд согласий создаст новый
+	idempotencyKey := fmt.Sprintf("reconnect:%d:%s", chatID, round)
+	newChat, err := s.createChat(ctx, chat.GetMemberIds(), idempotencyKey)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания чата через gRPC: %w", err)
+	}
+	if err := s.redisRepo.SaveReconnectResult(ctx, chatID, newChat.GetPublicId(), s.searchCfg.ReconnectWindow); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+
+	log.Printf("🔁 Участники чата %d снова вместе в чате %d", chatID, newChat.GetChatId())
+	return &model.ReconnectStatus{Status: model.ReconnectMatched, ChatID: newChat.GetPublicId()}, nil
+}
+
+// ReconnectStatus - состояние запроса на повторный чат с точки зрения пользователя
+func (s *MatchmakingService) ReconnectStatus(ctx context.Context, userID int64, publicChatID string) (*model.ReconnectStatus, error) {
+	chat, err := findUserChat(ctx, s.chatSvc, userID, publicChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	newChatID, optedIn, ttl, err := s.redisRepo.GetReconnectState(ctx, chat.GetChatId(), userID, s.searchCfg.ReconnectWindow)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case newChatID != "":
+		return &model.ReconnectStatus{Status: model.ReconnectMatched, ChatID: newChatID}, nil
+	case optedIn:
+		return &model.ReconnectStatus{Status: model.ReconnectPending, ExpiresInSec: int64(ttl.Seconds())}, nil
+	default:
+		return &model.ReconnectStatus{Status: model.ReconnectNone}, nil
+	}
+}
+
+// findUserChat - чат пользователя из chat-service по публичному ID;
+// ErrChatNotFound, если пользователь в нём не участвовал
+func findUserChat(ctx context.Context, chatSvc chatpb.ChatServiceClient, userID int64, publicChatID string) (*chatpb.ChatInfo, error) {
+	resp, err := chatSvc.GetUserChats(ctx, &chatpb.GetUserChatsRequest{UserId: userID})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения чатов через gRPC: %w", err)
+	}
+	for _, chat := range resp.GetChats() {
+		if chat.GetPublicId() == publicChatID {
+			return chat, nil
+		}
+	}
+	return nil, ErrChatNotFound
+}
+
+// chatPartners - участники чата, кроме самого пользователя
+func chatPartners(chat *chatpb.ChatInfo, userID int64) []int64 {
+	partners := make([]int64, 0, len(chat.GetMemberIds()))
+	for _, id := range chat.GetMemberIds() {
+		if id != userID {
+			partners = append(partners, id)
+		}
+	}
+	return partners
+}
+
+// BlockUser - блокирует собеседников из чата пользователя (по публичному ID), чтобы больше
+// никогда не попадать с ними в один чат. Кого блокировать, определяется по участникам чата,
+// а не по идентификатору от клиента.
+func (s *MatchmakingService) BlockUser(ctx context.Context, userID int64, publicChatID string) error {
+	chat, err := findUserChat(ctx, s.chatSvc, userID, publicChatID)
+	if err != nil {
+		return err
+	}
+	partners := chatPartners(chat, userID)
+	if len(partners) == 0 {
+		return ErrNoPartners
+	}
+	for _, partnerID := range partners {
+		if err := s.redisRepo.BlockUser(ctx, userID, partnerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueThroughput - сколько матчей состоялось в очереди комнат roomSize за последние
+// matchRateWindow и сколько ожидающих в секунду они забирали из этой очереди
+func (s *MatchmakingService) queueThroughput(ctx context.Context, roomSize int) (int64, float64, error) {
+	matches, consumed, err := s.redisRepo.MatchesSince(ctx, roomSize, time.Now().Add(-matchRateWindow))
+	if err != nil {
+		return 0, 0, err
+	}
+	return matches, float64(consumed) / matchRateWindow.Seconds(), nil
+}
+
+// QueueStatus - позиция пользователя в очереди и оценка времени ожидания
+func (s *MatchmakingService) QueueStatus(ctx context.Context, userID int64) (*model.QueueStatus, error) {
+	rank, length, roomSize, inQueue, err := s.redisRepo.QueuePosition(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &model.QueueStatus{InQueue: inQueue, QueueLength: length}
+	if !inQueue {
+		return status, nil
+	}
+	status.Position = rank + 1
+
+	// Пользователю на позиции N нужно дождаться, пока матчи его очереди заберут
+	// N ожидающих; в групповой комнате один матч забирает сразу несколько
+	_, waitersPerSec, err := s.queueThroughput(ctx, roomSize)
+	if err != nil {
+		return nil, err
+	}
+	if waitersPerSec > 0 {
+		eta := int64(float64(status.Position)/waitersPerSec + 0.5)
+		status.ETASeconds = &eta
+	}
+	return status, nil
+}
+
+// QueueStats - агрегированная статистика по всем очередям (1:1 и групповым комнатам)
+func (s *MatchmakingService) QueueStats(ctx context.Context) (*model.QueueStats, error) {
+	stats := &model.QueueStats{
+		ByRoomSize:              make(map[int]int64, s.searchCfg.MaxRoomSize-1),
+		WaitersMatchedPerMinute: make(map[int]float64, s.searchCfg.MaxRoomSize-1),
+		CollectedAt:             time.Now().UTC(),
+	}
+
+	var matches int64
+	for roomSize := 2; roomSize <= s.searchCfg.MaxRoomSize; roomSize++ {
+		length, err := s.redisRepo.QueueLength(ctx, roomSize)
+		if err != nil {
+			return nil, err
+		}
+		stats.QueueLength += length
+		stats.ByRoomSize[roomSize] = length
+
+		queueMatches, waitersPerSec, err := s.queueThroughput(ctx, roomSize)
+		if err != nil {
+			return nil, err
+		}
+		matches += queueMatches
+		stats.WaitersMatchedPerMinute[roomSize] = waitersPerSec * 60
+
+		oldest, ok, err := s.redisRepo.OldestEnqueuedAt(ctx, roomSize)
+		if err != nil {
+			return nil, err
+		}
+		if wait := int64(time.Since(oldest).Seconds()); ok && wait > stats.OldestWaitSec {
+			stats.OldestWaitSec = wait
+		}
+	}
+	stats.MatchesPerMinute = float64(matches) / matchRateWindow.Minutes()
+	return stats, nil
+}
+
+// RunStatsPublisher - периодически публикует статистику очереди в Redis для дашбордов
+func (s *MatchmakingService) RunStatsPublisher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := s.QueueStats(ctx)
+			if err != nil {
+				log.Printf("⚠️ Ошибка сбора статистики очереди: %v", err)
+				continue
+			}
+			payload, err := json.Marshal(stats)
+			if err != nil {
+				log.Printf("⚠️ Ошибка сериализации статистики очереди: %v", err)
+				continue
+			}
+			if err := s.redisRepo.PublishStats(ctx, payload); err != nil {
+				log.Printf("⚠️ %v", err)
+			}
+		}
+	}
 }
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"matchmaking-service/internal/config"
+	"matchmaking-service/internal/grpc/chatpb"
+	"matchmaking-service/internal/repository"
+	"matchmaking-service/pkg/model"
+)
+
+const (
+	// reportCooldown - повторная жалоба на того же пользователя в течение этого времени не снижает репутацию
+	reportCooldown = 24 * time.Hour
+	// chatRatedTTL - сколько помнить, что завершённый чат уже учтён в репутации
+	chatRatedTTL = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidScore - репутация вне допустимых границ
+	ErrInvalidScore = errors.New("недопустимое значение репутации")
+)
+
+// ReputationService - репутация пользователей: жалобы, быстрые пропуски и длина чатов
+type ReputationService struct {
+	redisRepo *repository.RedisRepository
+	chatSvc   chatpb.ChatServiceClient
+	cfg       config.ReputationConfig
+}
+
+func NewReputationService(
+	redisRepo *repository.RedisRepository,
+	chatSvc chatpb.ChatServiceClient,
+	cfg config.ReputationConfig,
+) *ReputationService {
+	return &ReputationService{
+		redisRepo: redisRepo,
+		chatSvc:   chatSvc,
+		cfg:       cfg,
+	}
+}
+
+// Tiers - параметры уровней репутации для подбора собеседников
+func (s *ReputationService) Tiers() repository.ReputationTiers {
+	return repository.ReputationTiers{
+		Default:    s.cfg.Default,
+		Thresholds: s.cfg.TierThresholds,
+		RelaxAfter: s.cfg.TierRelaxAfter,
+	}
+}
+
+// Report - жалоба участника чата на собеседников из этого чата (по публичному ID); на кого
+// жалоба, определяется по участникам чата, а не по идентификатору от клиента. Повторные
+// жалобы на того же пользователя в течение reportCooldown не учитываются.
+func (s *ReputationService) Report(ctx context.Context, reporterID int64, publicChatID string) error {
+	chat, err := findUserChat(ctx, s.chatSvc, reporterID, publicChatID)
+	if err != nil {
+		return err
+	}
+	partners := chatPartners(chat, reporterID)
+	if len(partners) == 0 {
+		return ErrNoPartners
+	}
+	for _, reportedID := range partners {
+		if err := s.penalizeReported(ctx, reporterID, reportedID, chat.GetChatId()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// penalizeReported - снижает репутацию пользователя по жалобе, если reporterID
+// не жаловался на него в течение reportCooldown
+func (s *ReputationService) penalizeReported(ctx context.Context, reporterID, reportedID, chatID int64) error {
+	applied, err := s.redisRepo.ApplyReport(ctx, reporterID, reportedID, s.cfg.ReportPenalty, s.cfg.Default, s.cfg.Min, s.cfg.Max, reportCooldown)
+	if err != nil || !applied {
+		return err
+	}
+	log.Printf("🚩 Жалоба пользователя %d на пользователя %d (чат %d) учтена", reporterID, reportedID, chatID)
+	return nil
+}
+
+// RateEndedChat - учитывает в репутации завершённый чат: тех, кого пропустили или покинули
+// в первые секунды, штрафуем, а участникам долгого чата начисляем бонус. Заброшенный чат,
+// архивированный по сроку, никто не завершал, поэтому за него только начисляется бонус.
+// Каждый чат учитывается один раз.
+func (s *ReputationService) RateEndedChat(ctx context.Context, chat repository.ChatEnded) error {
+	duration := chat.EndedAt.Sub(chat.CreatedAt)
+	var delta int
+	switch {
+	case duration < s.cfg.QuickSkipWindow && chat.EndedBy != 0:
+		delta = -s.cfg.QuickSkipPenalty
+	case duration >= s.cfg.LongChatDuration:
+		delta = s.cfg.LongChatBonus
+	default:
+		return nil
+	}
+
+	members := make([]int64, 0, len(chat.MemberIDs))
+	for _, memberID := range chat.MemberIDs {
+		// Быстрое завершение штрафует только тех, кого пропустили
+		if delta < 0 && memberID == chat.EndedBy {
+			continue
+		}
+		members = append(members, memberID)
+	}
+	applied, err := s.redisRepo.ApplyChatRating(ctx, chat.ChatID, members, delta, s.cfg.Default, s.cfg.Min, s.cfg.Max, chatRatedTTL)
+	if err != nil || !applied {
+		return err
+	}
+	log.Printf("⚖️ Чат %d длился %v: репутация участников изменена на %+d", chat.ChatID, duration, delta)
+	return nil
+}
+
+// RunChatEndedListener - учитывает в репутации чаты, завершённые в chat-service любым
+// способом (пропуск, выход, архивация по сроку), пока не отменён ctx
+func (s *ReputationService) RunChatEndedListener(ctx context.Context) {
+	for event := range s.redisRepo.SubscribeChatEnded(ctx) {
+		if err := s.RateEndedChat(ctx, event); err != nil {
+			log.Printf("⚠️ Не удалось учесть чат %d в репутации: %v", event.ChatID, err)
+		}
+	}
+}
+
+// endedChatOf - событие о завершении чата по ответу gRPC-метода EndChat
+func endedChatOf(chatID, endedBy int64, reason string, resp *chatpb.EndChatResponse) (repository.ChatEnded, error) {
+	createdAt, err := time.Parse(time.RFC3339, resp.GetCreatedAt())
+	if err != nil {
+		return repository.ChatEnded{}, fmt.Errorf("неверное время создания чата %q: %w", resp.GetCreatedAt(), err)
+	}
+	endedAt, err := time.Parse(time.RFC3339, resp.GetEndedAt())
+	if err != nil {
+		return repository.ChatEnded{}, fmt.Errorf("неверное время завершения чата %q: %w", resp.GetEndedAt(), err)
+	}
+	return repository.ChatEnded{
+		ChatID:    chatID,
+		MemberIDs: resp.GetMemberIds(),
+		CreatedAt: createdAt,
+		EndedAt:   endedAt,
+		EndedBy:   endedBy,
+		Reason:    reason,
+	}, nil
+}
+
+// Get - репутация пользователя и его уровень
+func (s *ReputationService) Get(ctx context.Context, userID int64) (*model.Reputation, error) {
+	score, err := s.redisRepo.GetReputation(ctx, userID, s.cfg.Default)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Reputation{UserID: userID, Score: score, Tier: s.cfg.Tier(score)}, nil
+}
+
+// Set - устанавливает репутацию пользователя вручную (админское API)
+func (s *ReputationService) Set(ctx context.Context, userID int64, score int) (*model.Reputation, error) {
+	if score < s.cfg.Min || score > s.cfg.Max {
+		return nil, fmt.Errorf("%w: допустимо от %d до %d", ErrInvalidScore, s.cfg.Min, s.cfg.Max)
+	}
+	if err := s.redisRepo.SetReputation(ctx, userID, score); err != nil {
+		return nil, err
+	}
+	log.Printf("🛠️ Репутация пользователя %d установлена вручную: %d", userID, score)
+	return &model.Reputation{UserID: userID, Score: score, Tier: s.cfg.Tier(score)}, nil
+}
@@ -4,8 +4,7 @@ import "time"
 
 // Chat - структура для хранения информации о чате
 type Chat struct {
-	ID        int64     `gorm:"primaryKey" json:"id"`
-	User1ID   int64     `gorm:"not null" json:"user1_id"`
-	User2ID   int64     `gorm:"not null" json:"user2_id"`
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	ID        int64     `json:"id"`
+	MemberIDs []int64   `json:"member_ids"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+// QueueStatus - положение пользователя в очереди поиска
+type QueueStatus struct {
+	InQueue     bool   `json:"in_queue"`
+	Position    int64  `json:"position,omitempty"`    // позиция в очереди, начиная с 1
+	QueueLength int64  `json:"queue_length"`          // сколько всего пользователей ждут
+	ETASeconds  *int64 `json:"eta_seconds,omitempty"` // nil, если оценить время ожидания нельзя
+}
+
+// QueueStats - агрегированная статистика очереди для мониторинга
+type QueueStats struct {
+	QueueLength             int64           `json:"queue_length"`
+	ByRoomSize              map[int]int64   `json:"by_room_size"` // размер комнаты -> число ожидающих
+	MatchesPerMinute        float64         `json:"matches_per_minute"`
+	WaitersMatchedPerMinute map[int]float64 `json:"waiters_matched_per_minute"` // размер комнаты -> сколько ожидающих в минуту забирают матчи
+	OldestWaitSec           int64           `json:"oldest_wait_seconds"`
+	CollectedAt             time.Time       `json:"collected_at"`
+}
+
+// MatchOutcome - результат (или промежуточное событие) поиска собеседника
+type MatchOutcome string
+
+const (
+	OutcomeMatch       MatchOutcome = "match"        // собеседник найден, чат создан
+	OutcomeTimeout     MatchOutcome = "timeout"      // время поиска истекло
+	OutcomeLowPriority MatchOutcome = "low_priority" // поиск продолжается в низкоприоритетном пуле
+	OutcomeBotMatch    MatchOutcome = "bot_match"    // людей нет, создан чат с ботом
+	OutcomeCancelled   MatchOutcome = "cancelled"    // поиск отменён клиентом
+	OutcomeRequeued    MatchOutcome = "requeued"     // чат создать не удалось, поиск продолжается с начала очереди
+)
+
+// MatchResult - событие поиска собеседника
+type MatchResult struct {
+	Outcome MatchOutcome `json:"event"`
+	ChatID  string       `json:"data,omitempty"` // публичный ID созданного чата
+}
+
+// Final - завершает ли событие поиск
+func (r MatchResult) Final() bool {
+	return r.Outcome != OutcomeLowPriority && r.Outcome != OutcomeRequeued
+}
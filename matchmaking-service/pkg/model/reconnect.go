@@ -0,0 +1,18 @@
+package model
+
+// ReconnectState - состояние запроса на повторный чат с прошлым собеседником
+type ReconnectState string
+
+const (
+	ReconnectNone    ReconnectState = "none"    // пользователь не просил о повторном чате
+	ReconnectPending ReconnectState = "pending" // согласие дано, ждём остальных участников
+	ReconnectMatched ReconnectState = "matched" // согласились все, создан новый чат
+)
+
+// ReconnectStatus - ответ на запрос повторного чата. Согласие других участников
+// не раскрывается, пока не согласятся все.
+type ReconnectStatus struct {
+	Status       ReconnectState `json:"status"`
+	ChatID       string         `json:"chat_id,omitempty"`            // публичный ID нового чата, если Status == matched
+	ExpiresInSec int64          `json:"expires_in_seconds,omitempty"` // сколько ещё действует согласие
+}
@@ -0,0 +1,8 @@
+package model
+
+// Reputation - репутация пользователя и уровень, внутри которого он подбирается
+type Reputation struct {
+	UserID int64 `json:"user_id"`
+	Score  int   `json:"score"`
+	Tier   int   `json:"tier"` // 0 - самый низкий уровень
+}